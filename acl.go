@@ -0,0 +1,453 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/pkg/cid"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	sc "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+// AnyRole in an ACL rule lets callers through whatever their role, including
+// callers without a role attribute
+const AnyRole = "*"
+
+// ACLRule : roles allowed to call a chaincode function, and optionally the MSPs
+// the caller must belong to. An empty MSPs list allows every MSP.
+type ACLRule struct {
+	Roles []string `json:"roles"`
+	MSPs  []string `json:"msps,omitempty"`
+}
+
+// ACL : access control document kept on the ledger. Rules and role bindings
+// stored on the ledger override defaultACL and defaultRoleMSPs entry by entry,
+// so functions added by a chaincode upgrade are covered before the ledger
+// document mentions them.
+type ACL struct {
+	Rules     map[string]ACLRule  `json:"rules"`
+	RoleMSPs  map[string][]string `json:"rolemsps"`
+	Version   int                 `json:"version"`
+	UpdatedBy string              `json:"updatedby,omitempty"`
+	UpdatedAt string              `json:"updatedat,omitempty"`
+}
+
+// defaultACL : rules applied to every function the ledger ACL does not override.
+// Functions missing here can not be called at all.
+var defaultACL = map[string]ACLRule{
+	"queryLicense":             {Roles: []string{AnyRole}},
+	"initLedger":               {Roles: []string{AnyRole}},
+	"createLearnerLicense":     {Roles: []string{"org1-approver"}},
+	"inputTest1Result":         {Roles: []string{"org1-examcenter1"}},
+	"inputTest2Result":         {Roles: []string{"org1-examcenter2"}},
+	"inputTest3Result":         {Roles: []string{"org1-examcenter3"}},
+	"getHistoryForAsset":       {Roles: []string{"org1-approver", "org1-adjudicator"}, MSPs: []string{"Org1MSP"}},
+	"queryLearnerList":         {Roles: []string{AnyRole}},
+	"restictedMethod":          {Roles: []string{"org1-approver"}},
+	"queryWaitingList":         {Roles: []string{"org1-approver"}},
+	"upgradeLearnerToActive":   {Roles: []string{"org1-approver"}},
+	"queryActiveList":          {Roles: []string{AnyRole}},
+	"createPoliceReport":       {Roles: []string{"org2-police"}},
+	"queryComplainByLicenseNo": {Roles: []string{AnyRole}},
+	"queryToStallList":         {Roles: []string{"org1-approver", "org2-police"}},
+	"revokeLicense":            {Roles: []string{"org1-approver"}},
+	"queryStalledList":         {Roles: []string{AnyRole}},
+	"archiveLicense":           {Roles: []string{"org1-approver"}},
+	"deleteLicense":            {Roles: []string{"org1-approver"}},
+	"purgeLicense":             {Roles: []string{"org1-admin"}},
+	"reinstateLicense":         {Roles: []string{"org1-approver"}},
+	"renewLicense":             {Roles: []string{"org1-approver"}},
+	"queryExpiringList":        {Roles: []string{"org1-approver"}},
+	"sweepExpiredLearners":     {Roles: []string{"org1-approver"}},
+	"sweepExpiredLicenses":     {Roles: []string{"org1-approver"}},
+	"queryLapsingLearners":     {Roles: []string{"org1-approver"}},
+	"recalculatePoints":        {Roles: []string{"org1-approver", "org2-police"}},
+	"fileAppeal":               {Roles: []string{"org1-approver", "org1-holder"}},
+	"resolveAppeal":            {Roles: []string{"org1-adjudicator"}},
+	"recordTestResult":         {Roles: []string{"org1-examcenter1", "org1-examcenter2", "org1-examcenter3"}},
+	"queryTestAttempts":        {Roles: []string{"org1-approver", "org1-examcenter1", "org1-examcenter2", "org1-examcenter3"}},
+	"queryTestTypes":           {Roles: []string{AnyRole}},
+	"registerTestType":         {Roles: []string{"org1-admin"}},
+	"addEndorsement":           {Roles: []string{"org1-approver"}},
+	"approveEndorsement":       {Roles: []string{"org1-approver"}},
+	"cancelEndorsement":        {Roles: []string{"org1-approver"}},
+	"queryLicenseClasses":      {Roles: []string{AnyRole}},
+	"registerLicenseClass":     {Roles: []string{"org1-admin"}},
+	"queryConfig":              {Roles: []string{AnyRole}},
+	"updateConfig":             {Roles: []string{"org1-admin"}},
+	"queryACL":                 {Roles: []string{"org1-admin"}},
+	"updateACL":                {Roles: []string{"org1-admin"}},
+	"updateRoleBindings":       {Roles: []string{"org1-admin"}},
+	"migrateLedger":            {Roles: []string{"org1-admin"}},
+	"searchLicenses":           {Roles: []string{"org1-approver", "org2-police"}},
+	"queryLicenseDocuments":    {Roles: []string{"org1-approver", "org2-police"}},
+	"queryReportDocuments":     {Roles: []string{"org1-approver", "org1-adjudicator", "org2-police"}},
+	"queryReportsByHolder":     {Roles: []string{"org1-approver", "org1-adjudicator", "org2-police"}},
+	"queryLicenseByNID":        {Roles: []string{"org1-approver", "org2-police"}},
+	"correctNID":               {Roles: []string{"org1-approver"}},
+	"setNIDKey":                {Roles: []string{"org1-admin"}},
+	"correctDOB":               {Roles: []string{"org1-approver"}},
+	"queryHolderPII":           {Roles: []string{"org1-approver", "org1-adjudicator"}, MSPs: []string{"Org1MSP"}},
+	// not a function: callers matching it get personal data in query results
+	readPIIRight: {Roles: []string{"org1-approver", "org1-adjudicator"}, MSPs: []string{"Org1MSP"}},
+}
+
+// adminFunctions : functions that govern the chaincode itself, its access
+// control, configuration and keys. updateACL may hand them to other roles but
+// never opens them to every role.
+var adminFunctions = map[string]bool{
+	"updateACL":            true,
+	"updateRoleBindings":   true,
+	"queryACL":             true,
+	"setNIDKey":            true,
+	"updateConfig":         true,
+	"migrateLedger":        true,
+	"registerTestType":     true,
+	"registerLicenseClass": true,
+	"purgeLicense":         true,
+}
+
+// defaultRoleMSPs : MSPs whose CA may issue each role. A role attribute is only
+// trusted when the caller belongs to one of the MSPs bound to it, so another
+// organization's CA can not enroll its users as approvers; roles bound to no
+// MSP are refused.
+var defaultRoleMSPs = map[string][]string{
+	"org1-approver":    {"Org1MSP"},
+	"org1-examcenter1": {"Org1MSP"},
+	"org1-examcenter2": {"Org1MSP"},
+	"org1-examcenter3": {"Org1MSP"},
+	"org1-holder":      {"Org1MSP"},
+	"org1-adjudicator": {"Org1MSP"},
+	"org1-admin":       {"Org1MSP"},
+	"org2-police":      {"Org2MSP"},
+}
+
+func aclKey(APIstub shim.ChaincodeStubInterface) (string, error) {
+	return APIstub.CreateCompositeKey("acl~key", []string{"current"})
+}
+
+// getStoredACL returns the ACL document as stored on the ledger, holding only
+// the rules that override defaultACL
+func getStoredACL(APIstub shim.ChaincodeStubInterface) (ACL, error) {
+	acl := ACL{Rules: map[string]ACLRule{}, RoleMSPs: map[string][]string{}}
+
+	key, err := aclKey(APIstub)
+	if err != nil {
+		return acl, err
+	}
+	aclAsBytes, err := APIstub.GetState(key)
+	if err != nil {
+		return acl, fmt.Errorf("Failed to read ACL: %s", err.Error())
+	}
+	if aclAsBytes != nil {
+		if err := json.Unmarshal(aclAsBytes, &acl); err != nil {
+			return acl, fmt.Errorf("Failed to decode ACL: %s", err.Error())
+		}
+		if acl.Rules == nil {
+			acl.Rules = map[string]ACLRule{}
+		}
+		if acl.RoleMSPs == nil {
+			acl.RoleMSPs = map[string][]string{}
+		}
+	}
+
+	return acl, nil
+}
+
+// putACL stores the ACL document under aclEndorsers, which the next update has
+// to satisfy
+func putACL(APIstub shim.ChaincodeStubInterface, acl ACL) error {
+	aclAsBytes, err := json.Marshal(acl)
+	if err != nil {
+		return err
+	}
+	key, err := aclKey(APIstub)
+	if err != nil {
+		return err
+	}
+	if err := APIstub.PutState(key, aclAsBytes); err != nil {
+		return err
+	}
+	_, err = requireEndorsers(APIstub, key, aclEndorsers)
+	return err
+}
+
+// roleMSP returns the MSP a role is named after. Roles are named orgN-..., and
+// only OrgNMSP may be bound to them, so the roles of one organization can not
+// be handed to the users of another.
+func roleMSP(role string) (string, bool) {
+	dash := strings.Index(role, "-")
+	if !strings.HasPrefix(role, "org") || dash < 4 {
+		return "", false
+	}
+	if _, err := strconv.Atoi(role[3:dash]); err != nil {
+		return "", false
+	}
+	return "Org" + role[3:dash] + "MSP", true
+}
+
+// getACL returns the effective ACL: defaultACL overlaid with the rules stored on the ledger
+func getACL(APIstub shim.ChaincodeStubInterface) (ACL, error) {
+	acl, err := getStoredACL(APIstub)
+	if err != nil {
+		return acl, err
+	}
+
+	for function, rule := range defaultACL {
+		if _, ok := acl.Rules[function]; !ok {
+			acl.Rules[function] = rule
+		}
+	}
+	for role, msps := range defaultRoleMSPs {
+		if _, ok := acl.RoleMSPs[role]; !ok {
+			acl.RoleMSPs[role] = msps
+		}
+	}
+
+	return acl, nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// authorize checks the caller against the ACL rule of the function before Invoke dispatches it
+func authorize(APIstub shim.ChaincodeStubInterface, function string) error {
+	acl, err := getACL(APIstub)
+	if err != nil {
+		return err
+	}
+	rule, ok := acl.Rules[function]
+	if !ok {
+		return fmt.Errorf("Access denied: %s is not in the ACL", function)
+	}
+
+	mspID, err := cid.GetMSPID(APIstub)
+	if err != nil {
+		return fmt.Errorf("Error while retriving MSP ID: %s", err.Error())
+	}
+
+	if len(rule.MSPs) > 0 && !contains(rule.MSPs, mspID) {
+		return denied(function, "", mspID, "members of "+mspID+" can not call "+function)
+	}
+
+	if contains(rule.Roles, AnyRole) {
+		return nil
+	}
+
+	val, ok, err := cid.GetAttributeValue(APIstub, "role")
+	if err != nil {
+		return fmt.Errorf("Error while retriving attributes")
+	}
+	if !ok {
+		return denied(function, "", mspID, "client identity doesnot posses the role attribute")
+	}
+	if !contains(rule.Roles, val) {
+		return denied(function, val, mspID, "only users with role "+strings.Join(rule.Roles, " or ")+" have access to "+function)
+	}
+
+	// the role is only as trustworthy as the CA that issued it
+	if !contains(acl.RoleMSPs[val], mspID) {
+		if len(acl.RoleMSPs[val]) == 0 {
+			return denied(function, val, mspID, "role "+val+" is not bound to any MSP")
+		}
+		return denied(function, val, mspID, "role "+val+" is only valid from "+strings.Join(acl.RoleMSPs[val], " or ")+", not "+mspID)
+	}
+
+	return nil
+}
+
+// denied logs a refused call with the identity that made it and returns the error for the caller
+func denied(function string, role string, mspID string, reason string) error {
+	logger.Warningf("Access denied to %s for role %q of %s: %s", function, role, mspID, reason)
+	return fmt.Errorf("Access denied: %s", reason)
+}
+
+func (s *SmartContract) queryACL(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 0 {
+		return shim.Error("Incorrect number of arguments. Expecting 0")
+	}
+
+	acl, err := getACL(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	aclAsBytes, err := json.Marshal(acl)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(aclAsBytes)
+}
+
+// updateACL replaces the rules of the functions named in the given JSON object,
+// e.g. {"createPoliceReport": {"roles": ["org2-police", "org3-police"]}}.
+// Only known functions can be given rules, and adminFunctions, updateACL among
+// them, must stay restricted to named roles so the ACL can not be locked or
+// opened up.
+func (s *SmartContract) updateACL(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	rules := map[string]ACLRule{}
+	if err := json.Unmarshal([]byte(args[0]), &rules); err != nil {
+		return shim.Error("Invalid ACL rules: " + err.Error())
+	}
+	if len(rules) == 0 {
+		return shim.Error("No ACL rules given")
+	}
+
+	functions := make([]string, 0, len(rules))
+	for function := range rules {
+		functions = append(functions, function)
+	}
+	sort.Strings(functions)
+
+	for _, function := range functions {
+		rule := rules[function]
+		if _, ok := defaultACL[function]; !ok {
+			return shim.Error("Unknown function in ACL rules: " + function)
+		}
+		if len(rule.Roles) == 0 {
+			return shim.Error("ACL rule for " + function + " needs at least one role")
+		}
+		if adminFunctions[function] && contains(rule.Roles, AnyRole) {
+			return shim.Error(function + " can not be opened to every role")
+		}
+	}
+
+	acl, err := getStoredACL(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	actor, err := cid.GetID(APIstub)
+	if err != nil {
+		return shim.Error("Error while retriving client identity")
+	}
+	now, err := txTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	for _, function := range functions {
+		acl.Rules[function] = rules[function]
+	}
+	acl.Version++
+	acl.UpdatedBy = actor
+	acl.UpdatedAt = now.Format(time.RFC3339)
+
+	if err := putACL(APIstub, acl); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	effective, err := getACL(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	aclAsBytes, err := json.Marshal(effective)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(aclAsBytes)
+}
+
+// updateRoleBindings replaces the MSPs bound to the roles named in the given
+// JSON object, e.g. {"org3-police": ["Org3MSP"]}. Binding a role to an empty
+// list withdraws it from every organization. A role can only be bound to the
+// MSP it is named after (roleMSP).
+func (s *SmartContract) updateRoleBindings(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	bindings := map[string][]string{}
+	if err := json.Unmarshal([]byte(args[0]), &bindings); err != nil {
+		return shim.Error("Invalid role bindings: " + err.Error())
+	}
+	if len(bindings) == 0 {
+		return shim.Error("No role bindings given")
+	}
+	for role := range bindings {
+		if role == "" || role == AnyRole {
+			return shim.Error("Invalid role in role bindings: " + role)
+		}
+		if bindings[role] == nil {
+			bindings[role] = []string{}
+		}
+		msp, ok := roleMSP(role)
+		if !ok {
+			return shim.Error("Invalid role in role bindings: " + role + ", roles are named orgN-<name>")
+		}
+		for _, bound := range bindings[role] {
+			if bound != msp {
+				return shim.Error("Role " + role + " can only be bound to " + msp + ", not " + bound)
+			}
+		}
+	}
+
+	acl, err := getStoredACL(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	actor, err := cid.GetID(APIstub)
+	if err != nil {
+		return shim.Error("Error while retriving client identity")
+	}
+	now, err := txTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	for role, msps := range bindings {
+		acl.RoleMSPs[role] = msps
+	}
+
+	// whoever may change the bindings must still be able to log in afterwards
+	effective, err := getACL(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	for role, msps := range acl.RoleMSPs {
+		effective.RoleMSPs[role] = msps
+	}
+	governed := false
+	for _, role := range effective.Rules["updateRoleBindings"].Roles {
+		if len(effective.RoleMSPs[role]) > 0 {
+			governed = true
+		}
+	}
+	if !governed {
+		return shim.Error("Role bindings would leave no role able to call updateRoleBindings")
+	}
+
+	acl.Version++
+	acl.UpdatedBy = actor
+	acl.UpdatedAt = now.Format(time.RFC3339)
+
+	if err := putACL(APIstub, acl); err != nil {
+		return shim.Error(err.Error())
+	}
+	effective.Version, effective.UpdatedBy, effective.UpdatedAt = acl.Version, acl.UpdatedBy, acl.UpdatedAt
+
+	aclAsBytes, err := json.Marshal(effective)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(aclAsBytes)
+}
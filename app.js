@@ -272,6 +272,9 @@ app.post('/channels/:channelName/chaincodes/:chaincodeName', async function (req
 		var channelName = req.params.channelName;
 		var fcn = req.body.fcn;
 		var args = req.body.args;
+		// personal data, e.g. {"pii": {"name": ..., "nid": ..., "dob": ...}}, is
+		// sent as transient data and kept out of the logs
+		var transient = req.body.transient;
 		logger.debug('channelName  : ' + channelName);
 		logger.debug('chaincodeName : ' + chaincodeName);
 		logger.debug('fcn  : ' + fcn);
@@ -294,7 +297,7 @@ app.post('/channels/:channelName/chaincodes/:chaincodeName', async function (req
 		}
 
 		const start = Date.now();
-		let message = await invoke.invokeChaincode(peers, channelName, chaincodeName, fcn, args, req.username, req.orgname);
+		let message = await invoke.invokeChaincode(peers, channelName, chaincodeName, fcn, args, req.username, req.orgname, transient);
 		const latency = Date.now() - start;
 
 
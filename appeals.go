@@ -0,0 +1,205 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-chaincode-go/pkg/cid"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	sc "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+// Violation report statuses. Upheld and Dismissed are final.
+const (
+	ReportFiled       = "Filed"
+	ReportUnderAppeal = "UnderAppeal"
+	ReportUpheld      = "Upheld"
+	ReportDismissed   = "Dismissed"
+)
+
+// reportTransitions : statuses a report may move to, keyed by its current status
+var reportTransitions = map[string][]string{
+	ReportFiled:       {ReportUnderAppeal},
+	ReportUnderAppeal: {ReportUpheld, ReportDismissed},
+	ReportUpheld:      {},
+	ReportDismissed:   {},
+}
+
+// getReport reads a violation report, treating reports filed before report
+// statuses were recorded as Filed
+func getReport(APIstub shim.ChaincodeStubInterface, id string) (TrafficRuleViolatonReport, error) {
+	report := TrafficRuleViolatonReport{}
+
+	reportAsBytes, err := APIstub.GetState(id)
+	if err != nil {
+		return report, fmt.Errorf("Failed to read report %s: %s", id, err.Error())
+	}
+	if reportAsBytes == nil {
+		return report, fmt.Errorf("Report %s does not exist", id)
+	}
+	if err := json.Unmarshal(reportAsBytes, &report); err != nil {
+		return report, fmt.Errorf("Failed to decode report %s: %s", id, err.Error())
+	}
+	if report.Holder == "" || report.PointsDeduction <= 0 {
+		return report, fmt.Errorf("Key %s does not hold a violation report", id)
+	}
+	if report.Status == "" {
+		report.Status = ReportFiled
+	}
+
+	return report, nil
+}
+
+func putReport(APIstub shim.ChaincodeStubInterface, report TrafficRuleViolatonReport) ([]byte, error) {
+	report.DocType, report.SchemaVersion = DocTypeReport, SchemaVersion
+	reportAsBytes, err := json.Marshal(report)
+	if err != nil {
+		return nil, err
+	}
+	if err := APIstub.PutState(report.ID, reportAsBytes); err != nil {
+		return nil, err
+	}
+	return reportAsBytes, nil
+}
+
+func setReportStatus(report *TrafficRuleViolatonReport, to string) error {
+	for _, next := range reportTransitions[report.Status] {
+		if next == to {
+			report.Status = to
+			return nil
+		}
+	}
+	return fmt.Errorf("Illegal status transition for report %s: %s -> %s", report.ID, report.Status, to)
+}
+
+// fileAppeal puts a filed report under appeal. It can be filed by the holder of
+// the reported license, identified by the licenseid attribute of their
+// certificate, or by an approver on their behalf. args: report ID, reason.
+func (s *SmartContract) fileAppeal(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+	if args[1] == "" {
+		return shim.Error("An appeal reason is required")
+	}
+
+	report, err := getReport(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// the ACL lets holders in, but only for their own reports
+	val, _, err := cid.GetAttributeValue(APIstub, "role")
+	if err != nil {
+		return shim.Error("Error while retriving attributes")
+	}
+	if val == "org1-holder" {
+		licenseID, ok, err := cid.GetAttributeValue(APIstub, "licenseid")
+		if err != nil {
+			return shim.Error("Error while retriving attributes")
+		}
+		if !ok || licenseID != report.Holder {
+			return shim.Error("Holders can only appeal reports filed against their own license")
+		}
+	}
+
+	actor, err := cid.GetID(APIstub)
+	if err != nil {
+		return shim.Error("Error while retriving client identity")
+	}
+	now, err := txTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	if err := setReportStatus(&report, ReportUnderAppeal); err != nil {
+		return shim.Error(err.Error())
+	}
+	report.AppealReason = args[1]
+	report.AppealedBy = actor
+	report.AppealDate = timestamp(now)
+	emit(APIstub, Event{Type: EventAppealFiled, LicenseID: report.Holder, ReportID: report.ID})
+
+	reportAsBytes, err := putReport(APIstub, report)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(reportAsBytes)
+}
+
+// resolveAppeal upholds or dismisses a report under appeal. A dismissed report
+// refunds its points deduction, and a license flagged for stalling goes back
+// to Active once it has points again. args: report ID, Upheld|Dismissed, notes.
+func (s *SmartContract) resolveAppeal(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 3")
+	}
+	decision := args[1]
+	if decision != ReportUpheld && decision != ReportDismissed {
+		return shim.Error("Decision must be " + ReportUpheld + " or " + ReportDismissed)
+	}
+
+	report, err := getReport(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	actor, err := cid.GetID(APIstub)
+	if err != nil {
+		return shim.Error("Error while retriving client identity")
+	}
+	now, err := txTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	if err := setReportStatus(&report, decision); err != nil {
+		return shim.Error(err.Error())
+	}
+	report.ResolutionNotes = args[2]
+	report.ResolvedBy = actor
+	report.ResolvedDate = timestamp(now)
+	emit(APIstub, Event{Type: EventAppealResolved, LicenseID: report.Holder, ReportID: report.ID, Decision: decision})
+
+	if decision == ReportDismissed {
+		license, err := getLicense(APIstub, report.Holder)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+
+		// settle the points earned back while the report stood, the refund is
+		// added on top of them
+		if _, err := restorePoints(APIstub, &license, now); err != nil {
+			return shim.Error(err.Error())
+		}
+
+		points := license.Point + report.PointsDeduction
+		if points > InitialPoints {
+			points = InitialPoints
+		}
+		license.Point = points
+		emit(APIstub, Event{Type: EventPointsChanged, LicenseID: license.ID, Points: &points, Reason: PointsRefunded})
+		if err := applyClassThresholds(APIstub, &license); err != nil {
+			return shim.Error(err.Error())
+		}
+
+		// a Stalled license keeps its status, it is only reinstated by an approver
+		if license.Status == StatusToStall && points > 0 {
+			if err := setLicenseStatus(APIstub, &license, StatusActive); err != nil {
+				return shim.Error(err.Error())
+			}
+		}
+
+		if _, err := putLicense(APIstub, license); err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	reportAsBytes, err := putReport(APIstub, report)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(reportAsBytes)
+}
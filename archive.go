@@ -0,0 +1,124 @@
+package main
+
+import (
+	"github.com/hyperledger/fabric-chaincode-go/pkg/cid"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	sc "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+// archiveLicense cancels a license in place of deleting it. The record stays
+// on the ledger with the reason and who archived it, its violation reports and
+// test attempts stay linked to it, and its NID is released so a new license
+// can be issued for it. args: license ID, reason
+func (s *SmartContract) archiveLicense(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+	if args[1] == "" {
+		return shim.Error("An archival reason is required")
+	}
+
+	license, err := getLicense(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if license.Status == StatusCancelled {
+		return shim.Error("License " + license.ID + " is already archived")
+	}
+
+	actor, err := cid.GetID(APIstub)
+	if err != nil {
+		return shim.Error("Error while retriving client identity")
+	}
+	now, err := txTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	if err := setLicenseStatus(APIstub, &license, StatusCancelled); err != nil {
+		return shim.Error(err.Error())
+	}
+	if _, err := delStaleStatusIndexes(APIstub, license); err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := delNIDIndex(APIstub, license); err != nil {
+		return shim.Error(err.Error())
+	}
+	license.ArchivedReason = args[1]
+	license.ArchivedBy = actor
+	license.ArchivedDate = timestamp(now)
+
+	licenseAsBytes, err := putLicense(APIstub, license)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(licenseAsBytes)
+}
+
+// deleteLicense was replaced by archiveLicense, which keeps the record and its
+// reports. It is kept so existing clients get told what to call instead.
+func (s *SmartContract) deleteLicense(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+	return shim.Error("deleteLicense has been replaced by archiveLicense, licenses are no longer deleted")
+}
+
+// purgeLicense erases the personal data of an archived license where the law
+// requires it. The private copy is purged from PIICollection together with its
+// private history, and the hashes of it are removed from the public record,
+// which stays so its reports keep their holder. Earlier public versions keep
+// the hashes, which without the purged salt and the NID key tell nothing;
+// versions written before schema version 4 still hold the data itself in the
+// ledger history, which no transaction can change. Purging needs Fabric 2.5
+// peers and the V2_5 application capability on the channel.
+// args: license ID, legal basis of the erasure
+func (s *SmartContract) purgeLicense(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+	if args[1] == "" {
+		return shim.Error("The legal basis of the erasure is required")
+	}
+
+	license, err := getLicense(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if license.Status != StatusCancelled {
+		return shim.Error("License " + license.ID + " is " + license.Status + ", only archived licenses can be purged")
+	}
+	if license.PurgedDate != nil {
+		return shim.Error("License " + license.ID + " is already purged")
+	}
+
+	actor, err := cid.GetID(APIstub)
+	if err != nil {
+		return shim.Error("Error while retriving client identity")
+	}
+	now, err := txTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// licenses archived before their NID index was cleaned up still have an
+	// entry pointing at them
+	if err := delNIDIndex(APIstub, license); err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := APIstub.PurgePrivateData(PIICollection, license.ID); err != nil {
+		return shim.Error("Failed to purge personal data of license " + license.ID + ", purging needs Fabric 2.5 peers and the V2_5 application capability: " + err.Error())
+	}
+
+	license.Name, license.NID, license.DOB = "", "", ""
+	license.PIIHash, license.NIDHash = "", ""
+	license.PurgeReason = args[1]
+	license.PurgedBy = actor
+	license.PurgedDate = timestamp(now)
+
+	licenseAsBytes, err := putLicense(APIstub, license)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	emit(APIstub, Event{Type: EventPurged, LicenseID: license.ID})
+
+	return shim.Success(licenseAsBytes)
+}
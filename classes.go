@@ -0,0 +1,402 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	sc "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+// LicenseClass : a class of vehicles a license can cover. A class needs its
+// RequiredTests passed and the holder to be at least MinAge, and it can only be
+// used while the license has more than PointThreshold points.
+type LicenseClass struct {
+	ID             string   `json:"id"`
+	Name           string   `json:"name"`
+	RequiredTests  []string `json:"requiredtests"`
+	MinAge         int      `json:"minage"`
+	PointThreshold int      `json:"pointthreshold"`
+}
+
+// Endorsement : a class a license holder is working towards on top of the
+// classes their license already covers. It goes through the same Learner and
+// Waiting steps as a learner permit while the license itself stays Active.
+type Endorsement struct {
+	Class             string      `json:"class"`
+	Status            string      `json:"status"`
+	Tests             TestResults `json:"tests"`
+	RequiredTests     []string    `json:"requiredtests"`
+	LearnerExpiryDate *time.Time  `json:"learnerexpirydate"`
+	RequestedDate     *time.Time  `json:"requesteddate"`
+}
+
+// DefaultClass : class of licenses created without one, including every
+// license written before classes existed
+const DefaultClass = "light"
+
+// defaultLicenseClasses : classes known before any is registered on the ledger.
+// Classes registered on the ledger override these by ID.
+var defaultLicenseClasses = map[string]LicenseClass{
+	"motorcycle": {ID: "motorcycle", Name: "Motorcycle", RequiredTests: []string{"test1", "test3"}, MinAge: 16, PointThreshold: 0},
+	"light":      {ID: "light", Name: "Light vehicle", RequiredTests: []string{"test1", "test2", "test3"}, MinAge: 18, PointThreshold: 0},
+	"heavy":      {ID: "heavy", Name: "Heavy vehicle", RequiredTests: []string{"test1", "test2", "test3"}, MinAge: 21, PointThreshold: 5},
+	"psv":        {ID: "psv", Name: "Public service vehicle", RequiredTests: []string{"test1", "test2", "test3"}, MinAge: 21, PointThreshold: 8},
+}
+
+// getLicenseClasses returns the class registry: defaultLicenseClasses overlaid
+// with the classes stored under class~key
+func getLicenseClasses(APIstub shim.ChaincodeStubInterface) (map[string]LicenseClass, error) {
+	classes := map[string]LicenseClass{}
+	for id, class := range defaultLicenseClasses {
+		classes[id] = class
+	}
+
+	resultsIterator, err := APIstub.GetStateByPartialCompositeKey("class~key", []string{})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	for resultsIterator.HasNext() {
+		responseRange, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		class := LicenseClass{}
+		if err := json.Unmarshal(responseRange.Value, &class); err != nil {
+			return nil, fmt.Errorf("Failed to decode license class %s: %s", responseRange.Key, err.Error())
+		}
+		classes[class.ID] = class
+	}
+
+	return classes, nil
+}
+
+// ageAt returns the age in whole years at the given time of someone born on
+// dob, a YYYY-MM-DD date
+func ageAt(dob string, at time.Time) (int, error) {
+	born, err := time.Parse("2006-01-02", dob)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid date of birth %q, expecting YYYY-MM-DD", dob)
+	}
+	age := at.Year() - born.Year()
+	if at.Month() < born.Month() || (at.Month() == born.Month() && at.Day() < born.Day()) {
+		age--
+	}
+	return age, nil
+}
+
+// checkMinAge fails if the holder is too young for the class
+func checkMinAge(dob string, class LicenseClass, at time.Time) error {
+	if dob == "" {
+		return fmt.Errorf("A date of birth is needed to check the minimum age of %d for %s, record it with correctDOB", class.MinAge, class.Name)
+	}
+	age, err := ageAt(dob, at)
+	if err != nil {
+		return err
+	}
+	if age < class.MinAge {
+		return fmt.Errorf("%s requires a minimum age of %d, holder is %d", class.Name, class.MinAge, age)
+	}
+	return nil
+}
+
+// applyClassThresholds suspends the classes of a license whose point threshold
+// has been reached and gives back those it is above again. Falling to zero
+// points is handled by the license status, not here.
+func applyClassThresholds(APIstub shim.ChaincodeStubInterface, license *License) error {
+	classes, err := getLicenseClasses(APIstub)
+	if err != nil {
+		return err
+	}
+
+	held := append(append([]string{}, license.Classes...), license.SuspendedClasses...)
+	sort.Strings(held)
+
+	license.Classes, license.SuspendedClasses = []string{}, []string{}
+	for _, id := range held {
+		if class, ok := classes[id]; ok && class.PointThreshold > 0 && license.Point <= class.PointThreshold {
+			license.SuspendedClasses = append(license.SuspendedClasses, id)
+		} else {
+			license.Classes = append(license.Classes, id)
+		}
+	}
+
+	return nil
+}
+
+// endorsementStalled reports whether an endorsement can no longer be completed:
+// its learner permit has lapsed or a required test has no attempts left
+func endorsementStalled(APIstub shim.ChaincodeStubInterface, license License, endorsement Endorsement, now time.Time) (bool, error) {
+	if endorsement.Status != StatusLearner {
+		return false, nil
+	}
+	if permitLapsed(endorsement.LearnerExpiryDate, now) {
+		return true, nil
+	}
+
+	testTypes, err := getTestTypes(APIstub)
+	if err != nil {
+		return false, err
+	}
+	for _, id := range endorsement.RequiredTests {
+		testType, ok := testTypes[id]
+		if !ok || testType.MaxAttempts == 0 || endorsement.Tests[id] {
+			continue
+		}
+		history, err := testAttempts(APIstub, license.ID, id)
+		if err != nil {
+			return false, err
+		}
+		if len(attemptsFor(history, license, endorsement.Class, endorsement.RequestedDate)) >= testType.MaxAttempts {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// addEndorsement starts the learner flow for a new class on an Active license,
+// starting it over if the endorsement in progress has lapsed or run out of
+// attempts. args: license ID, class ID; the minimum age is checked against the
+// personal data of the license, passed in the transient map (HolderTransientKey).
+func (s *SmartContract) addEndorsement(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+
+	license, err := getLicense(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if license.Status != StatusActive {
+		return shim.Error("License " + license.ID + " is " + license.Status + ", only Active licenses can be endorsed")
+	}
+
+	classes, err := getLicenseClasses(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	class, ok := classes[args[1]]
+	if !ok {
+		return shim.Error("Unknown license class " + args[1])
+	}
+	if contains(license.Classes, class.ID) || contains(license.SuspendedClasses, class.ID) {
+		return shim.Error("License " + license.ID + " already covers " + class.Name)
+	}
+
+	config, err := getConfig(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	now, err := txTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if current, ok := license.Endorsements[class.ID]; ok {
+		stalled, err := endorsementStalled(APIstub, license, current, now)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if !stalled {
+			return shim.Error("License " + license.ID + " already has an endorsement for " + class.Name + " in progress")
+		}
+	}
+	holder, err := currentPII(APIstub, license)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := checkMinAge(holder.DOB, class, now); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	required, err := classTests(APIstub, class)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	endorsement := Endorsement{
+		Class:             class.ID,
+		Status:            StatusLearner,
+		Tests:             TestResults{},
+		RequiredTests:     required,
+		LearnerExpiryDate: timestamp(now.Add(days(config.LearnerValidityDays))),
+		RequestedDate:     timestamp(now),
+	}
+	for _, id := range required {
+		endorsement.Tests[id] = false
+	}
+	license.Endorsements[class.ID] = endorsement
+	emit(APIstub, Event{Type: EventEndorsementAdded, LicenseID: license.ID, Class: class.ID, ToStatus: endorsement.Status})
+
+	licenseAsBytes, err := putLicense(APIstub, license)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(licenseAsBytes)
+}
+
+// approveEndorsement adds a class to a license once its endorsement has every
+// required test passed. args: license ID, class ID
+func (s *SmartContract) approveEndorsement(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+
+	license, err := getLicense(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if license.Status != StatusActive {
+		return shim.Error("License " + license.ID + " is " + license.Status + ", only Active licenses can be endorsed")
+	}
+	endorsement, ok := license.Endorsements[args[1]]
+	if !ok {
+		return shim.Error("License " + license.ID + " has no endorsement for " + args[1] + " in progress")
+	}
+	if endorsement.Status != StatusWaiting {
+		return shim.Error("Endorsement " + args[1] + " of license " + license.ID + " is " + endorsement.Status + ", only Waiting endorsements can be approved")
+	}
+
+	delete(license.Endorsements, args[1])
+	license.Classes = append(license.Classes, args[1])
+	emit(APIstub, Event{Type: EventActivated, LicenseID: license.ID, Class: args[1], FromStatus: StatusWaiting, ToStatus: StatusActive})
+	if err := applyClassThresholds(APIstub, &license); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	licenseAsBytes, err := putLicense(APIstub, license)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(licenseAsBytes)
+}
+
+// cancelEndorsement withdraws the endorsement in progress for a class, e.g.
+// one the holder gave up on. Its test attempts stay recorded but do not count
+// against a later endorsement for the class. args: license ID, class ID
+func (s *SmartContract) cancelEndorsement(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+
+	license, err := getLicense(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	endorsement, ok := license.Endorsements[args[1]]
+	if !ok {
+		return shim.Error("License " + license.ID + " has no endorsement for " + args[1] + " in progress")
+	}
+
+	delete(license.Endorsements, args[1])
+	emit(APIstub, Event{Type: EventEndorsementCancelled, LicenseID: license.ID, Class: args[1], FromStatus: endorsement.Status})
+
+	licenseAsBytes, err := putLicense(APIstub, license)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(licenseAsBytes)
+}
+
+// classTests returns the tests required for a class, falling back to the tests
+// the registry marks as required when the class names none
+func classTests(APIstub shim.ChaincodeStubInterface, class LicenseClass) ([]string, error) {
+	testTypes, err := getTestTypes(APIstub)
+	if err != nil {
+		return nil, err
+	}
+
+	required := class.RequiredTests
+	if len(required) == 0 {
+		required = requiredTests(testTypes)
+	}
+	if len(required) == 0 {
+		return nil, fmt.Errorf("No tests are required for %s, register one before creating learners", class.Name)
+	}
+	for _, id := range required {
+		if _, ok := testTypes[id]; !ok {
+			return nil, fmt.Errorf("%s requires unknown test type %s", class.Name, id)
+		}
+	}
+
+	return required, nil
+}
+
+func (s *SmartContract) queryLicenseClasses(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 0 {
+		return shim.Error("Incorrect number of arguments. Expecting 0")
+	}
+
+	classes, err := getLicenseClasses(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	ids := make([]string, 0, len(classes))
+	for id := range classes {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	list := make([]LicenseClass, 0, len(ids))
+	for _, id := range ids {
+		list = append(list, classes[id])
+	}
+
+	classesAsBytes, err := json.Marshal(list)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(classesAsBytes)
+}
+
+// registerLicenseClass adds a class to the registry or replaces one.
+// args: class JSON, e.g.
+// {"id":"heavy","name":"Heavy vehicle","requiredtests":["test1","test3"],"minage":21,"pointthreshold":5}
+func (s *SmartContract) registerLicenseClass(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	class := LicenseClass{}
+	if err := json.Unmarshal([]byte(args[0]), &class); err != nil {
+		return shim.Error("Invalid license class: " + err.Error())
+	}
+	if class.ID == "" || class.Name == "" {
+		return shim.Error("License class needs an id and name")
+	}
+	if class.MinAge < 0 || class.PointThreshold < 0 || class.PointThreshold >= InitialPoints {
+		return shim.Error("License class minage can not be negative and pointthreshold must be between 0 and " + strconv.Itoa(InitialPoints-1))
+	}
+	if _, err := classTests(APIstub, class); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	key, err := APIstub.CreateCompositeKey("class~key", []string{class.ID})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	classAsBytes, err := json.Marshal(class)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := APIstub.PutState(key, classAsBytes); err != nil {
+		return shim.Error(err.Error())
+	}
+	emit(APIstub, Event{Type: EventLicenseClassRegistered, Class: class.ID})
+
+	return shim.Success(classAsBytes)
+}
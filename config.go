@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	sc "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+// Config : chaincode settings that can be changed on the ledger without an upgrade
+type Config struct {
+	MinSuspensionDays    int `json:"minsuspensiondays"`
+	LicenseValidityYears int `json:"licensevalidityyears"`
+	LearnerValidityDays  int `json:"learnervaliditydays"`
+
+	// every PointRestorePeriodDays without a violation gives back PointRestoreAmount points
+	PointRestorePeriodDays int `json:"pointrestoreperioddays"`
+	PointRestoreAmount     int `json:"pointrestoreamount"`
+}
+
+// defaultConfig is used until an admin stores a config with updateConfig
+var defaultConfig = Config{
+	MinSuspensionDays:    30,
+	LicenseValidityYears: 5,
+	LearnerValidityDays:  180,
+
+	PointRestorePeriodDays: 365,
+	PointRestoreAmount:     3,
+}
+
+// the config is kept under a composite key so it can never clash with a license or report ID
+func configKey(APIstub shim.ChaincodeStubInterface) (string, error) {
+	return APIstub.CreateCompositeKey("config~key", []string{"current"})
+}
+
+func getConfig(APIstub shim.ChaincodeStubInterface) (Config, error) {
+	config := defaultConfig
+
+	key, err := configKey(APIstub)
+	if err != nil {
+		return config, err
+	}
+	configAsBytes, err := APIstub.GetState(key)
+	if err != nil {
+		return config, fmt.Errorf("Failed to read config: %s", err.Error())
+	}
+	if configAsBytes == nil {
+		return config, nil
+	}
+	if err := json.Unmarshal(configAsBytes, &config); err != nil {
+		return config, fmt.Errorf("Failed to decode config: %s", err.Error())
+	}
+
+	return config, nil
+}
+
+func (c Config) validate() error {
+	if c.MinSuspensionDays < 0 {
+		return fmt.Errorf("minsuspensiondays can not be negative")
+	}
+	if c.LicenseValidityYears <= 0 {
+		return fmt.Errorf("licensevalidityyears must be positive")
+	}
+	if c.LearnerValidityDays <= 0 {
+		return fmt.Errorf("learnervaliditydays must be positive")
+	}
+	if c.PointRestorePeriodDays <= 0 {
+		return fmt.Errorf("pointrestoreperioddays must be positive")
+	}
+	if c.PointRestoreAmount < 0 {
+		return fmt.Errorf("pointrestoreamount can not be negative")
+	}
+	return nil
+}
+
+// txTime returns the transaction timestamp, which unlike the wall clock is the
+// same on every endorsing peer
+func txTime(APIstub shim.ChaincodeStubInterface) (time.Time, error) {
+	ts, err := APIstub.GetTxTimestamp()
+	if err != nil {
+		return time.Time{}, fmt.Errorf("Failed to read transaction timestamp: %s", err.Error())
+	}
+	return time.Unix(ts.Seconds, int64(ts.Nanos)).UTC(), nil
+}
+
+func days(n int) time.Duration {
+	return time.Duration(n) * 24 * time.Hour
+}
+
+func (s *SmartContract) queryConfig(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 0 {
+		return shim.Error("Incorrect number of arguments. Expecting 0")
+	}
+
+	config, err := getConfig(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	configAsBytes, err := json.Marshal(config)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(configAsBytes)
+}
+
+// updateConfig merges the given JSON into the current config, so fields left
+// out of the update keep their value
+func (s *SmartContract) updateConfig(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	config, err := getConfig(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := json.Unmarshal([]byte(args[0]), &config); err != nil {
+		return shim.Error("Invalid config: " + err.Error())
+	}
+	if err := config.validate(); err != nil {
+		return shim.Error("Invalid config: " + err.Error())
+	}
+
+	configAsBytes, err := json.Marshal(config)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	key, err := configKey(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := APIstub.PutState(key, configAsBytes); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(configAsBytes)
+}
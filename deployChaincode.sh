@@ -4,7 +4,7 @@ export PEER0_ORG1_CA=${PWD}/artifacts/channel/crypto-config/peerOrganizations/or
 export PEER0_ORG2_CA=${PWD}/artifacts/channel/crypto-config/peerOrganizations/org2.example.com/peers/peer0.org2.example.com/tls/ca.crt
 export FABRIC_CFG_PATH=${PWD}/artifacts/channel/config/
 
-export PRIVATE_DATA_CONFIG=${PWD}/artifacts/private-data/collections_config.json
+export PRIVATE_DATA_CONFIG=${PWD}/artifacts/src/github.com/fabcar/go/collections_config.json
 
 export CHANNEL_NAME=mychannel
 
@@ -237,6 +237,20 @@ chaincodeInvoke() {
         --peerAddresses localhost:9051 --tlsRootCertFiles $PEER0_ORG2_CA \
         -c '{"function": "initLedger","Args":[]}'
 
+    ## Set the key the NID index is hashed with, needs role=org1-admin. Keep
+    ## NID_KEY: creating licenses and correcting NIDs pass it again
+    export NID_KEY=${NID_KEY:-$(openssl rand -hex 32)}
+    export NID_KEY_TRANSIENT=$(echo -n "\"$NID_KEY\"" | base64 | tr -d \\n)
+    peer chaincode invoke -o localhost:7050 \
+        --ordererTLSHostnameOverride orderer.example.com \
+        --tls $CORE_PEER_TLS_ENABLED \
+        --cafile $ORDERER_CA \
+        -C $CHANNEL_NAME -n ${CC_NAME} \
+        --peerAddresses localhost:7051 --tlsRootCertFiles $PEER0_ORG1_CA \
+        --peerAddresses localhost:9051 --tlsRootCertFiles $PEER0_ORG2_CA \
+        -c '{"function": "setNIDKey","Args":[]}' \
+        --transient "{\"nidKey\":\"$NID_KEY_TRANSIENT\"}"
+
     ## Add private data
     # export CAR=$(echo -n "{\"key\":\"1111\", \"make\":\"Tesla\",\"model\":\"Tesla A1\",\"color\":\"White\",\"owner\":\"pavan\",\"price\":\"10000\"}" | base64 | tr -d \\n)
     # peer chaincode invoke -o localhost:7050 \
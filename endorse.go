@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+
+	"github.com/hyperledger/fabric-chaincode-go/pkg/statebased"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+// Key-level endorsement policies pin the keys that record police action to
+// both organizations. They replace the chaincode endorsement policy for every
+// later write of a key, so they never name fewer organizations than it does. A
+// new key is still created under the chaincode policy: a report can only be
+// filed against an Active or ToStall license, whose key already needs both
+// organizations, and that covers its creation too.
+
+// reportEndorsers : MSPs whose peers must endorse every change to a violation
+// report and its crime~key entry. Police file reports, but Org1 appeals and
+// resolves them on the same key, so a policy naming Org2MSP alone would let
+// Org2 peers rewrite an appeal decision and the deduction it refunded.
+var reportEndorsers = []string{"Org1MSP", "Org2MSP"}
+
+// licenseEndorsers : MSPs whose peers must endorse every change to a license
+// once it has become Active, and to the index entries of the statuses police
+// action moves it through
+var licenseEndorsers = []string{"Org1MSP", "Org2MSP"}
+
+// aclEndorsers : MSPs whose peers must endorse every change to the ACL document,
+// its rules and its role bindings, so neither organization can grant itself
+// access alone
+var aclEndorsers = []string{"Org1MSP", "Org2MSP"}
+
+// endorsedStatuses : statuses whose licenses are under licenseEndorsers
+var endorsedStatuses = map[string]bool{
+	StatusActive:  true,
+	StatusToStall: true,
+	StatusStalled: true,
+}
+
+// endorsementPolicy builds a policy needing a peer of every given MSP
+func endorsementPolicy(msps []string) ([]byte, error) {
+	ep, err := statebased.NewStateEP(nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := ep.AddOrgs(statebased.RoleTypePeer, msps...); err != nil {
+		return nil, err
+	}
+	return ep.Policy()
+}
+
+// requireEndorsers sets the endorsement policy of a key unless it already has
+// that policy, and reports whether it had to be set
+func requireEndorsers(APIstub shim.ChaincodeStubInterface, key string, msps []string) (bool, error) {
+	policy, err := endorsementPolicy(msps)
+	if err != nil {
+		return false, err
+	}
+	current, err := APIstub.GetStateValidationParameter(key)
+	if err != nil {
+		return false, err
+	}
+	if bytes.Equal(current, policy) {
+		return false, nil
+	}
+	return true, APIstub.SetStateValidationParameter(key, policy)
+}
+
+// requireLicenseEndorsers puts a license in one of endorsedStatuses, and the
+// index entry of its status, under licenseEndorsers. It returns how many
+// policies it had to set.
+func requireLicenseEndorsers(APIstub shim.ChaincodeStubInterface, license License) (int, error) {
+	if !endorsedStatuses[license.Status] {
+		return 0, nil
+	}
+	indexKey, err := APIstub.CreateCompositeKey(statusIndex[license.Status], []string{"current", license.ID})
+	if err != nil {
+		return 0, err
+	}
+
+	set := 0
+	for _, key := range []string{license.ID, indexKey} {
+		changed, err := requireEndorsers(APIstub, key, licenseEndorsers)
+		if err != nil {
+			return set, err
+		}
+		if changed {
+			set++
+		}
+	}
+	return set, nil
+}
+
+// requireReportEndorsers puts a report and its crime~key entry under
+// reportEndorsers. It returns how many policies it had to set.
+func requireReportEndorsers(APIstub shim.ChaincodeStubInterface, report TrafficRuleViolatonReport) (int, error) {
+	crimeIndexKey, err := APIstub.CreateCompositeKey("crime~key", []string{report.Holder, report.ID})
+	if err != nil {
+		return 0, err
+	}
+
+	set := 0
+	for _, key := range []string{report.ID, crimeIndexKey} {
+		changed, err := requireEndorsers(APIstub, key, reportEndorsers)
+		if err != nil {
+			return set, err
+		}
+		if changed {
+			set++
+		}
+	}
+	return set, nil
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+// EventName : name of the single chaincode event a transaction emits. Fabric
+// keeps only the last event set by a transaction, so every transition is
+// buffered and sent together in one TransactionEvents payload. The payload is
+// described by docs/events.schema.json.
+const EventName = "LicensusEvents"
+
+// Event types
+const (
+	EventLicenseCreated       = "LicenseCreated"
+	EventTestRecorded         = "TestRecorded"
+	EventMovedToWaiting       = "MovedToWaiting"
+	EventActivated            = "Activated"
+	EventViolationReported    = "ViolationReported"
+	EventFlaggedForStall      = "FlaggedForStall"
+	EventRevoked              = "Revoked"
+	EventExpired              = "Expired"
+	EventCancelled            = "Cancelled"
+	EventPurged               = "Purged"
+	EventAppealFiled          = "AppealFiled"
+	EventAppealResolved       = "AppealResolved"
+	EventPointsChanged        = "PointsChanged"
+	EventNIDCorrected         = "NIDCorrected"
+	EventDOBCorrected         = "DOBCorrected"
+	EventEndorsementAdded     = "EndorsementAdded"
+	EventEndorsementCancelled = "EndorsementCancelled"
+
+	// registry events concern no license
+	EventTestTypeRegistered     = "TestTypeRegistered"
+	EventLicenseClassRegistered = "LicenseClassRegistered"
+)
+
+// Reasons given by PointsChanged
+const (
+	PointsRestored   = "Restored"
+	PointsRefunded   = "Refunded"
+	PointsReinstated = "Reinstated"
+)
+
+// statusEvents : event emitted when a license moves to each status
+var statusEvents = map[string]string{
+	StatusWaiting:   EventMovedToWaiting,
+	StatusActive:    EventActivated,
+	StatusToStall:   EventFlaggedForStall,
+	StatusStalled:   EventRevoked,
+	StatusExpired:   EventExpired,
+	StatusCancelled: EventCancelled,
+}
+
+// Event : one thing that happened to a license or a registry. Only the fields
+// that apply to the event type are set.
+type Event struct {
+	Type       string `json:"type"`
+	LicenseID  string `json:"licenseId,omitempty"`
+	FromStatus string `json:"fromStatus,omitempty"`
+	ToStatus   string `json:"toStatus,omitempty"`
+	Class      string `json:"class,omitempty"`
+	TestType   string `json:"testType,omitempty"`
+	Score      *int   `json:"score,omitempty"`
+	Passed     *bool  `json:"passed,omitempty"`
+	ReportID   string `json:"reportId,omitempty"`
+	Level      string `json:"level,omitempty"`
+	Points     *int   `json:"points,omitempty"`
+	Reason     string `json:"reason,omitempty"`
+	Decision   string `json:"decision,omitempty"`
+}
+
+// TransactionEvents : payload of EventName, the events of one transaction in
+// the order they happened
+type TransactionEvents struct {
+	TxID      string    `json:"txId"`
+	Function  string    `json:"function"`
+	Timestamp time.Time `json:"timestamp"`
+	Events    []Event   `json:"events"`
+}
+
+// eventStub buffers the events of the transaction it serves. Invoke wraps the
+// stub it is given in one, so the functions it calls can emit events without
+// any state shared between transactions.
+type eventStub struct {
+	shim.ChaincodeStubInterface
+	events []Event
+}
+
+// emit buffers an event for the current transaction
+func emit(APIstub shim.ChaincodeStubInterface, event Event) {
+	if stub, ok := APIstub.(*eventStub); ok {
+		stub.events = append(stub.events, event)
+	}
+}
+
+// flushEvents sets the buffered events as the event of the transaction
+func (stub *eventStub) flushEvents(function string) error {
+	if len(stub.events) == 0 {
+		return nil
+	}
+	now, err := txTime(stub)
+	if err != nil {
+		return err
+	}
+	payload, err := json.Marshal(TransactionEvents{
+		TxID:      stub.GetTxID(),
+		Function:  function,
+		Timestamp: now,
+		Events:    stub.events,
+	})
+	if err != nil {
+		return err
+	}
+	return stub.SetEvent(EventName, payload)
+}
@@ -20,7 +20,7 @@ var helper = require('./helper.js');
 var logger = helper.getLogger('invoke-chaincode');
 
 
-var invokeChaincode = async function (peerNames, channelName, chaincodeName, fcn, args, username, org_name) {
+var invokeChaincode = async function (peerNames, channelName, chaincodeName, fcn, args, username, org_name, transient) {
 	logger.debug(util.format('\n============ invoke transaction on channel %s ============\n', channelName));
 	var error_message = null;
 	var tx_id_string = null;
@@ -46,6 +46,12 @@ var invokeChaincode = async function (peerNames, channelName, chaincodeName, fcn
 			chainId: channelName,
 			txId: tx_id
 		};
+		if (transient) {
+			request.transientMap = {};
+			for (let key in transient) {
+				request.transientMap[key] = Buffer.from(JSON.stringify(transient[key]));
+			}
+		}
 
 		let results = await channel.sendTransactionProposal(request);
 
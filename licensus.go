@@ -5,13 +5,12 @@ import (
 	"encoding/json"
 	"fmt"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/hyperledger/fabric-chaincode-go/shim"
 	sc "github.com/hyperledger/fabric-protos-go/peer"
 	"github.com/hyperledger/fabric/common/flogging"
-
-	"github.com/hyperledger/fabric-chaincode-go/pkg/cid"
 )
 
 // SmartContract Define the Smart Contract structure
@@ -38,22 +37,243 @@ type Resp struct {
 }
 
 type License struct {
+	DocType       string `json:"docType"`
+	SchemaVersion int    `json:"schemaVersion"`
+
 	ID     string `json:"id"`
-	Name   string `json:"name"`
-	NID    string `json:"nid"`
 	Status string `json:"status"`
-	Test1  string `json:"test1"`
-	Test2  string `json:"test2"`
-	Test3  string `json:"test3"`
-	Point  string `json:"point"`
+	Point  int    `json:"point"`
+
+	// Name, NID and DOB live in PIICollection and are only filled in by
+	// loadPII; the public record keeps PIIHash and NIDHash. Licenses written
+	// before schema version 4 hold them here until migrateLedger moves them.
+	Name    string `json:"name,omitempty"`
+	NID     string `json:"nid,omitempty"`
+	DOB     string `json:"dob,omitempty"`
+	PIIHash string `json:"piiHash,omitempty"`
+	NIDHash string `json:"nidHash,omitempty"`
+
+	Tests         TestResults `json:"tests"`
+	RequiredTests []string    `json:"requiredtests"`
+
+	Class            string                 `json:"class"`
+	Classes          []string               `json:"classes"`
+	SuspendedClasses []string               `json:"suspendedclasses"`
+	Endorsements     map[string]Endorsement `json:"endorsements"`
+
+	LearnerExpiryDate *time.Time `json:"learnerexpirydate,omitempty"`
+
+	IssueDate   *time.Time `json:"issuedate,omitempty"`
+	ExpiryDate  *time.Time `json:"expirydate,omitempty"`
+	RenewedDate *time.Time `json:"reneweddate,omitempty"`
+
+	PointsRestoredDate *time.Time `json:"pointsrestoreddate,omitempty"`
+
+	StalledDate      *time.Time `json:"stalleddate,omitempty"`
+	ReinstatedDate   *time.Time `json:"reinstateddate,omitempty"`
+	ReinstatedReason string     `json:"reinstatedreason,omitempty"`
+
+	ArchivedDate   *time.Time `json:"archiveddate,omitempty"`
+	ArchivedReason string     `json:"archivedreason,omitempty"`
+	ArchivedBy     string     `json:"archivedby,omitempty"`
+
+	PurgedDate  *time.Time `json:"purgeddate,omitempty"`
+	PurgeReason string     `json:"purgereason,omitempty"`
+	PurgedBy    string     `json:"purgedby,omitempty"`
 }
 
+// InitialPoints : points a license starts with and can be reinstated up to
+const InitialPoints = 15
+
 type TrafficRuleViolatonReport struct {
-	ID              string `json:"id"`
-	Holder          string `json:"holder"`
-	Level           string `json:"level"`
-	Desc            string `json:"desc"`
-	PointsDeduction string `json:"pointsdeduction"`
+	DocType       string `json:"docType"`
+	SchemaVersion int    `json:"schemaVersion"`
+
+	ID              string     `json:"id"`
+	Holder          string     `json:"holder"`
+	Level           string     `json:"level"`
+	Desc            string     `json:"desc"`
+	PointsDeduction int        `json:"pointsdeduction"`
+	Date            *time.Time `json:"date,omitempty"`
+	Status          string     `json:"status,omitempty"`
+
+	AppealReason    string     `json:"appealreason,omitempty"`
+	AppealedBy      string     `json:"appealedby,omitempty"`
+	AppealDate      *time.Time `json:"appealdate,omitempty"`
+	ResolutionNotes string     `json:"resolutionnotes,omitempty"`
+	ResolvedBy      string     `json:"resolvedby,omitempty"`
+	ResolvedDate    *time.Time `json:"resolveddate,omitempty"`
+}
+
+// License statuses. A license only ever changes status through setLicenseStatus,
+// which checks licenseTransitions and keeps the status indexes in sync.
+const (
+	StatusLearner   = "Learner"
+	StatusWaiting   = "Waiting"
+	StatusActive    = "Active"
+	StatusToStall   = "ToStall"
+	StatusStalled   = "Stalled"
+	StatusExpired   = "Expired"
+	StatusCancelled = "Cancelled"
+)
+
+// statusIndex : composite key index listing the licenses of each status
+var statusIndex = map[string]string{
+	StatusLearner:   "learner~key",
+	StatusWaiting:   "waiting~key",
+	StatusActive:    "active~key",
+	StatusToStall:   "tostall~key",
+	StatusStalled:   "stalled~key",
+	StatusExpired:   "expired~key",
+	StatusCancelled: "cancelled~key",
+}
+
+// licenseTransitions : statuses a license may move to, keyed by its current status
+var licenseTransitions = map[string][]string{
+	StatusLearner:   {StatusWaiting, StatusExpired, StatusCancelled},
+	StatusWaiting:   {StatusActive, StatusCancelled},
+	StatusActive:    {StatusToStall, StatusStalled, StatusExpired, StatusCancelled},
+	StatusToStall:   {StatusActive, StatusStalled, StatusCancelled},
+	StatusStalled:   {StatusActive, StatusCancelled},
+	StatusExpired:   {StatusActive, StatusCancelled},
+	StatusCancelled: {},
+}
+
+func canTransition(from, to string) bool {
+	for _, next := range licenseTransitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// getLicense reads a license from the world state, failing if the key is
+// missing or does not hold a license
+func getLicense(APIstub shim.ChaincodeStubInterface, id string) (License, error) {
+	license := License{}
+
+	licenseAsBytes, err := APIstub.GetState(id)
+	if err != nil {
+		return license, fmt.Errorf("Failed to read license %s: %s", id, err.Error())
+	}
+	if licenseAsBytes == nil {
+		return license, fmt.Errorf("License %s does not exist", id)
+	}
+	if err := json.Unmarshal(licenseAsBytes, &license); err != nil {
+		return license, fmt.Errorf("Failed to decode license %s: %s", id, err.Error())
+	}
+	if _, ok := statusIndex[license.Status]; !ok {
+		return license, fmt.Errorf("Key %s does not hold a license", id)
+	}
+
+	if license.RequiredTests == nil {
+		license.RequiredTests = legacyTests
+	}
+
+	if license.Class == "" {
+		license.Class = DefaultClass
+	}
+	if license.Classes == nil {
+		license.Classes = []string{}
+		// an issued license covers the class it was issued for
+		if license.IssueDate != nil || license.Status == StatusActive || license.Status == StatusToStall || license.Status == StatusStalled {
+			license.Classes = []string{license.Class}
+		}
+	}
+	if license.SuspendedClasses == nil {
+		license.SuspendedClasses = []string{}
+	}
+	if license.Endorsements == nil {
+		license.Endorsements = map[string]Endorsement{}
+	}
+
+	return license, nil
+}
+
+// putLicense writes the license to the world state and returns the stored
+// bytes. Personal data putPII has stored in PIICollection is left out; a
+// license still holding its own in public state keeps it, at the schema
+// version that held it there, until migrateLedger moves it.
+func putLicense(APIstub shim.ChaincodeStubInterface, license License) ([]byte, error) {
+	license.DocType, license.SchemaVersion = DocTypeLicense, SchemaVersion
+	if license.PIIHash != "" {
+		license.Name, license.NID, license.DOB = "", "", ""
+	} else if hasPII(license) {
+		license.SchemaVersion = publicPIISchemaVersion
+	}
+	licenseAsBytes, err := json.Marshal(license)
+	if err != nil {
+		return nil, err
+	}
+	if err := APIstub.PutState(license.ID, licenseAsBytes); err != nil {
+		return nil, err
+	}
+	return licenseAsBytes, nil
+}
+
+func putStatusIndex(APIstub shim.ChaincodeStubInterface, status string, id string) error {
+	indexKey, err := APIstub.CreateCompositeKey(statusIndex[status], []string{"current", id})
+	if err != nil {
+		return err
+	}
+	return APIstub.PutState(indexKey, []byte{0x00})
+}
+
+func delStatusIndex(APIstub shim.ChaincodeStubInterface, status string, id string) error {
+	indexKey, err := APIstub.CreateCompositeKey(statusIndex[status], []string{"current", id})
+	if err != nil {
+		return err
+	}
+	return APIstub.DelState(indexKey)
+}
+
+// delStaleStatusIndexes removes the license from the index of every status
+// but its own and returns how many entries it removed
+func delStaleStatusIndexes(APIstub shim.ChaincodeStubInterface, license License) (int, error) {
+	removed := 0
+	for status := range statusIndex {
+		if status == license.Status {
+			continue
+		}
+		indexKey, err := APIstub.CreateCompositeKey(statusIndex[status], []string{"current", license.ID})
+		if err != nil {
+			return removed, err
+		}
+		stale, err := APIstub.GetState(indexKey)
+		if err != nil {
+			return removed, err
+		}
+		if stale != nil {
+			if err := APIstub.DelState(indexKey); err != nil {
+				return removed, err
+			}
+			removed++
+		}
+	}
+	return removed, nil
+}
+
+// setLicenseStatus moves the license to a new status, rejecting transitions that
+// licenseTransitions does not allow and moving the license between status indexes.
+// Licenses entering one of endorsedStatuses are put under licenseEndorsers.
+// The caller is responsible for writing the license itself with putLicense.
+func setLicenseStatus(APIstub shim.ChaincodeStubInterface, license *License, to string) error {
+	if !canTransition(license.Status, to) {
+		return fmt.Errorf("Illegal status transition for license %s: %s -> %s", license.ID, license.Status, to)
+	}
+
+	if err := delStatusIndex(APIstub, license.Status, license.ID); err != nil {
+		return err
+	}
+	if err := putStatusIndex(APIstub, to, license.ID); err != nil {
+		return err
+	}
+
+	emit(APIstub, Event{Type: statusEvents[to], LicenseID: license.ID, FromStatus: license.Status, ToStatus: to})
+	license.Status = to
+	_, err := requireLicenseEndorsers(APIstub, *license)
+	return err
 }
 
 // Init ;  Method for initializing smart contract
@@ -71,6 +291,26 @@ func (s *SmartContract) Invoke(APIstub shim.ChaincodeStubInterface) sc.Response
 	logger.Infof("Function name is:  %d", function)
 	logger.Infof("Args length is : %d", len(args))
 
+	if err := authorize(APIstub, function); err != nil {
+		if function == "createPoliceReport" {
+			return reportError(ErrUnauthorized, "", err.Error())
+		}
+		return shim.Error(err.Error())
+	}
+
+	stub := &eventStub{ChaincodeStubInterface: APIstub}
+	response := s.dispatch(stub, function, args)
+	if response.Status == shim.OK {
+		if err := stub.flushEvents(function); err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	return response
+}
+
+// dispatch calls the smart contract function named by the transaction
+func (s *SmartContract) dispatch(APIstub shim.ChaincodeStubInterface, function string, args []string) sc.Response {
 	if function == "queryLicense" {
 		return s.queryLicense(APIstub, args)
 	} else if function == "initLedger" {
@@ -105,452 +345,614 @@ func (s *SmartContract) Invoke(APIstub shim.ChaincodeStubInterface) sc.Response
 		return s.revokeLicense(APIstub, args)
 	} else if function == "queryStalledList" {
 		return s.queryStalledList(APIstub, args)
+	} else if function == "archiveLicense" {
+		return s.archiveLicense(APIstub, args)
 	} else if function == "deleteLicense" {
 		return s.deleteLicense(APIstub, args)
+	} else if function == "purgeLicense" {
+		return s.purgeLicense(APIstub, args)
+	} else if function == "reinstateLicense" {
+		return s.reinstateLicense(APIstub, args)
+	} else if function == "renewLicense" {
+		return s.renewLicense(APIstub, args)
+	} else if function == "queryExpiringList" {
+		return s.queryExpiringList(APIstub, args)
+	} else if function == "sweepExpiredLearners" {
+		return s.sweepExpiredLearners(APIstub, args)
+	} else if function == "sweepExpiredLicenses" {
+		return s.sweepExpiredLicenses(APIstub, args)
+	} else if function == "queryLapsingLearners" {
+		return s.queryLapsingLearners(APIstub, args)
+	} else if function == "recalculatePoints" {
+		return s.recalculatePoints(APIstub, args)
+	} else if function == "fileAppeal" {
+		return s.fileAppeal(APIstub, args)
+	} else if function == "resolveAppeal" {
+		return s.resolveAppeal(APIstub, args)
+	} else if function == "recordTestResult" {
+		return s.recordTestResult(APIstub, args)
+	} else if function == "queryTestAttempts" {
+		return s.queryTestAttempts(APIstub, args)
+	} else if function == "queryTestTypes" {
+		return s.queryTestTypes(APIstub, args)
+	} else if function == "registerTestType" {
+		return s.registerTestType(APIstub, args)
+	} else if function == "addEndorsement" {
+		return s.addEndorsement(APIstub, args)
+	} else if function == "approveEndorsement" {
+		return s.approveEndorsement(APIstub, args)
+	} else if function == "cancelEndorsement" {
+		return s.cancelEndorsement(APIstub, args)
+	} else if function == "queryLicenseClasses" {
+		return s.queryLicenseClasses(APIstub, args)
+	} else if function == "registerLicenseClass" {
+		return s.registerLicenseClass(APIstub, args)
+	} else if function == "queryConfig" {
+		return s.queryConfig(APIstub, args)
+	} else if function == "updateConfig" {
+		return s.updateConfig(APIstub, args)
+	} else if function == "queryACL" {
+		return s.queryACL(APIstub, args)
+	} else if function == "updateACL" {
+		return s.updateACL(APIstub, args)
+	} else if function == "updateRoleBindings" {
+		return s.updateRoleBindings(APIstub, args)
+	} else if function == "migrateLedger" {
+		return s.migrateLedger(APIstub, args)
+	} else if function == "searchLicenses" {
+		return s.searchLicenses(APIstub, args)
+	} else if function == "queryLicenseDocuments" {
+		return s.queryLicenseDocuments(APIstub, args)
+	} else if function == "queryReportDocuments" {
+		return s.queryReportDocuments(APIstub, args)
+	} else if function == "queryReportsByHolder" {
+		return s.queryReportsByHolder(APIstub, args)
+	} else if function == "queryLicenseByNID" {
+		return s.queryLicenseByNID(APIstub, args)
+	} else if function == "correctNID" {
+		return s.correctNID(APIstub, args)
+	} else if function == "setNIDKey" {
+		return s.setNIDKey(APIstub, args)
+	} else if function == "queryHolderPII" {
+		return s.queryHolderPII(APIstub, args)
+	} else if function == "correctDOB" {
+		return s.correctDOB(APIstub, args)
 	}
 
 	return shim.Error("Invalid Smart Contract function name.")
 }
 
-func (S *SmartContract) deleteLicense(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
-	val, ok, err := cid.GetAttributeValue(APIstub, "role")
-	if err != nil {
-		// There was an error trying to retrieve the attribute
-		shim.Error("Error while retriving attributes")
-	}
-	if !ok {
-		// The client identity does not possess the attribute
-		shim.Error("Client identity doesnot posses the attribute")
-	}
-	// Do something with the value of 'val'
-	if val != "org1-approver" {
-		fmt.Println("Attribute role: " + val)
-		return shim.Error("Only user with role as ORG1-Approver have access this method!")
+func (s *SmartContract) revokeLicense(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
 	}
 
-	if len(args) != 1 {
-		return shim.Error("Incorrect number of arguments. Expecting 1")
+	license, err := getLicense(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
 	}
-	licenseAsBytes, _ := APIstub.GetState(args[0])
-	license := License{}
-
-	json.Unmarshal(licenseAsBytes, &license)
 
-	current := "current"
-	colorNameIndexKey, err := APIstub.CreateCompositeKey("learner~key", []string{current, args[0]})
+	now, err := txTime(APIstub)
 	if err != nil {
 		return shim.Error(err.Error())
 	}
-	APIstub.DelState(colorNameIndexKey)
 
-	colorNameIndexKey, err = APIstub.CreateCompositeKey("active~key", []string{current, args[0]})
-	if err != nil {
+	if err := setLicenseStatus(APIstub, &license, StatusStalled); err != nil {
 		return shim.Error(err.Error())
 	}
-	APIstub.DelState(colorNameIndexKey)
+	license.StalledDate = timestamp(now)
 
-	colorNameIndexKey, err = APIstub.CreateCompositeKey("stalled~key", []string{current, args[0]})
+	licenseAsBytes, err := putLicense(APIstub, license)
 	if err != nil {
 		return shim.Error(err.Error())
 	}
-	APIstub.DelState(colorNameIndexKey)
-
-	APIstub.DelState(args[0])
 
-	return shim.Success(nil)
+	return shim.Success(licenseAsBytes)
 }
 
-func (S *SmartContract) queryStalledList(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+// reinstateLicense moves a Stalled license back to Active once the minimum
+// suspension period has been served. args: license ID, reason and optionally
+// the points to restore, which defaults to a full reset to InitialPoints.
+func (s *SmartContract) reinstateLicense(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) != 2 && len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 2 or 3")
+	}
+	if args[1] == "" {
+		return shim.Error("A reinstatement reason is required")
+	}
 
-	if len(args) != 1 {
-		return shim.Error("Incorrect number of arguments")
+	points := InitialPoints
+	if len(args) == 3 {
+		var err error
+		points, err = strconv.Atoi(args[2])
+		if err != nil || points <= 0 || points > InitialPoints {
+			return shim.Error("Points to restore must be a number between 1 and " + strconv.Itoa(InitialPoints))
+		}
 	}
-	current := "current"
 
-	ownerAndIdResultIterator, err := APIstub.GetStateByPartialCompositeKey("stalled~key", []string{current})
+	license, err := getLicense(APIstub, args[0])
 	if err != nil {
 		return shim.Error(err.Error())
 	}
+	if license.Status != StatusStalled {
+		return shim.Error("License " + license.ID + " is " + license.Status + ", only Stalled licenses can be reinstated")
+	}
 
-	defer ownerAndIdResultIterator.Close()
-
-	var i int
-	var id string
-
-	var licenses []byte
-	bArrayMemberAlreadyWritten := false
-
-	licenses = append([]byte("["))
-
-	for i = 0; ownerAndIdResultIterator.HasNext(); i++ {
-		responseRange, err := ownerAndIdResultIterator.Next()
-		if err != nil {
-			return shim.Error(err.Error())
-		}
+	config, err := getConfig(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	now, err := txTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
 
-		objectType, compositeKeyParts, err := APIstub.SplitCompositeKey(responseRange.Key)
-		if err != nil {
-			return shim.Error(err.Error())
+	// licenses stalled before the stall date was recorded have no suspension
+	// period to measure, so they are not held back
+	if license.StalledDate != nil {
+		eligibleAt := license.StalledDate.Add(days(config.MinSuspensionDays))
+		if now.Before(eligibleAt) {
+			return shim.Error("License " + license.ID + " can not be reinstated before " + eligibleAt.Format(time.RFC3339))
 		}
+	}
 
-		id = compositeKeyParts[1]
-		assetAsBytes, err := APIstub.GetState(id)
-
-		// frac := "," + id
+	if err := setLicenseStatus(APIstub, &license, StatusActive); err != nil {
+		return shim.Error(err.Error())
+	}
+	license.Point = points
+	emit(APIstub, Event{Type: EventPointsChanged, LicenseID: license.ID, Points: &points, Reason: PointsReinstated})
+	if err := applyClassThresholds(APIstub, &license); err != nil {
+		return shim.Error(err.Error())
+	}
+	license.ReinstatedDate = timestamp(now)
+	license.ReinstatedReason = args[1]
 
-		if bArrayMemberAlreadyWritten == true {
-			newBytes := append([]byte(","), assetAsBytes...)
-			licenses = append(licenses, newBytes...)
+	licenseAsBytes, err := putLicense(APIstub, license)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
 
-		} else {
-			// newBytes := append([]byte(id), assetAsBytes...)
-			licenses = append(licenses, assetAsBytes...)
-		}
+	return shim.Success(licenseAsBytes)
+}
 
-		fmt.Printf("Found a asset for index : %s asset id : ", objectType, compositeKeyParts[0], compositeKeyParts[1])
-		bArrayMemberAlreadyWritten = true
+// outstandingObligations lists what keeps a license from being renewed; an
+// empty list means the holder is in good standing
+func outstandingObligations(APIstub shim.ChaincodeStubInterface, license License) ([]string, error) {
+	var obligations []string
 
+	if license.Point <= 0 {
+		obligations = append(obligations, "no points remaining")
 	}
 
-	licenses = append(licenses, []byte("]")...)
+	reports, err := reportsForLicense(APIstub, license.ID)
+	if err != nil {
+		return nil, err
+	}
+	for _, report := range reports {
+		if report.Status == ReportUnderAppeal {
+			obligations = append(obligations, "report "+report.ID+" is under appeal")
+		}
+	}
 
-	return shim.Success(licenses)
+	return obligations, nil
 }
 
-func (S *SmartContract) queryToStallList(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
-	val, ok, err := cid.GetAttributeValue(APIstub, "role")
+// renewLicense extends the validity of an Active or Expired license by the
+// configured validity period, counted from the later of now and the current expiry
+func (s *SmartContract) renewLicense(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	license, err := getLicense(APIstub, args[0])
 	if err != nil {
-		// There was an error trying to retrieve the attribute
-		shim.Error("Error while retriving attributes")
+		return shim.Error(err.Error())
 	}
-	if !ok {
-		// The client identity does not possess the attribute
-		shim.Error("Client identity doesnot posses the attribute")
+	if license.Status != StatusActive && license.Status != StatusExpired {
+		return shim.Error("License " + license.ID + " is " + license.Status + ", only Active or Expired licenses can be renewed")
 	}
-	// Do something with the value of 'val'
-	if val != "org1-approver" && val != "org2-police" {
-		fmt.Println("Attribute role: " + val)
-		return shim.Error("Only user with role as ORG1 have access this method!")
+	// learner permits that lapsed were never issued and have nothing to renew
+	if license.IssueDate == nil {
+		return shim.Error("License " + license.ID + " was never issued and can not be renewed")
 	}
 
-	if len(args) != 1 {
-		return shim.Error("Incorrect number of arguments")
+	obligations, err := outstandingObligations(APIstub, license)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if len(obligations) > 0 {
+		return shim.Error("License " + license.ID + " has outstanding obligations: " + strings.Join(obligations, ", "))
 	}
-	current := "current"
 
-	ownerAndIdResultIterator, err := APIstub.GetStateByPartialCompositeKey("tostall~key", []string{current})
+	config, err := getConfig(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	now, err := txTime(APIstub)
 	if err != nil {
 		return shim.Error(err.Error())
 	}
 
-	defer ownerAndIdResultIterator.Close()
+	from := now
+	if license.ExpiryDate != nil && license.ExpiryDate.After(now) {
+		from = *license.ExpiryDate
+	}
 
-	var i int
-	var id string
+	if license.Status == StatusExpired {
+		if err := setLicenseStatus(APIstub, &license, StatusActive); err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+	license.ExpiryDate = timestamp(from.AddDate(config.LicenseValidityYears, 0, 0))
+	license.RenewedDate = timestamp(now)
 
-	var licenses []byte
-	bArrayMemberAlreadyWritten := false
+	licenseAsBytes, err := putLicense(APIstub, license)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(licenseAsBytes)
+}
 
-	licenses = append([]byte("["))
+// licensesInStatus loads every license listed in the index of the given status
+func licensesInStatus(APIstub shim.ChaincodeStubInterface, status string) ([]License, error) {
+	resultsIterator, err := APIstub.GetStateByPartialCompositeKey(statusIndex[status], []string{"current"})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
 
-	for i = 0; ownerAndIdResultIterator.HasNext(); i++ {
-		responseRange, err := ownerAndIdResultIterator.Next()
+	var licenses []License
+	for resultsIterator.HasNext() {
+		responseRange, err := resultsIterator.Next()
 		if err != nil {
-			return shim.Error(err.Error())
+			return nil, err
 		}
-
-		objectType, compositeKeyParts, err := APIstub.SplitCompositeKey(responseRange.Key)
+		_, compositeKeyParts, err := APIstub.SplitCompositeKey(responseRange.Key)
 		if err != nil {
-			return shim.Error(err.Error())
+			return nil, err
 		}
-
-		id = compositeKeyParts[1]
-		assetAsBytes, err := APIstub.GetState(id)
-
-		// frac := "," + id
-
-		if bArrayMemberAlreadyWritten == true {
-			newBytes := append([]byte(","), assetAsBytes...)
-			licenses = append(licenses, newBytes...)
-
-		} else {
-			// newBytes := append([]byte(id), assetAsBytes...)
-			licenses = append(licenses, assetAsBytes...)
+		license, err := getLicense(APIstub, compositeKeyParts[1])
+		if err != nil {
+			return nil, err
 		}
-
-		fmt.Printf("Found a asset for index : %s asset id : ", objectType, compositeKeyParts[0], compositeKeyParts[1])
-		bArrayMemberAlreadyWritten = true
-
+		licenses = append(licenses, license)
 	}
 
-	licenses = append(licenses, []byte("]")...)
-
-	return shim.Success(licenses)
+	return licenses, nil
 }
 
-func (s *SmartContract) revokeLicense(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
-	val, ok, err := cid.GetAttributeValue(APIstub, "role")
-	if err != nil {
-		// There was an error trying to retrieve the attribute
-		shim.Error("Error while retriving attributes")
-	}
-	if !ok {
-		// The client identity does not possess the attribute
-		shim.Error("Client identity doesnot posses the attribute")
+// queryExpiringList returns the issued licenses that expire within the given
+// number of days, including those already past their expiry date
+func (s *SmartContract) queryExpiringList(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
 	}
-	// Do something with the value of 'val'
-	if val != "org1-approver" {
-		fmt.Println("Attribute role: " + val)
-		return shim.Error("Only user with role as ORG1-Approver have access this method!")
+	window, err := strconv.Atoi(args[0])
+	if err != nil || window < 0 {
+		return shim.Error("Window must be a non-negative number of days")
 	}
 
-	if len(args) != 2 {
-		return shim.Error("Incorrect number of arguments. Expecting 2")
+	now, err := txTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
 	}
+	deadline := now.Add(days(window))
 
-	licenseAsBytes, _ := APIstub.GetState(args[0])
-	license := License{}
-
-	json.Unmarshal(licenseAsBytes, &license)
+	expiring := []License{}
+	for _, status := range []string{StatusActive, StatusToStall} {
+		licenses, err := licensesInStatus(APIstub, status)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		for _, license := range licenses {
+			if license.ExpiryDate != nil && !license.ExpiryDate.After(deadline) {
+				expiring = append(expiring, license)
+			}
+		}
+	}
 
-	indexName := "active~key"
-	current := "current"
-	colorNameIndexKey, err := APIstub.CreateCompositeKey(indexName, []string{current, args[0]})
+	expiring, err = withPII(APIstub, expiring)
 	if err != nil {
 		return shim.Error(err.Error())
 	}
-
-	APIstub.DelState(colorNameIndexKey)
-
-	indexName = "tostall~key"
-	current = "current"
-	colorNameIndexKey, err = APIstub.CreateCompositeKey(indexName, []string{current, args[0]})
+	licensesAsBytes, err := json.Marshal(expiring)
 	if err != nil {
 		return shim.Error(err.Error())
 	}
 
-	APIstub.DelState(colorNameIndexKey)
-
-	license.Status = "Stalled"
-
-	licenseAsBytes, _ = json.Marshal(license)
-	APIstub.PutState(args[0], licenseAsBytes)
-
-	indexName = "stalled~key"
+	return shim.Success(licensesAsBytes)
+}
 
-	colorIndexKey, errr := APIstub.CreateCompositeKey(indexName, []string{current, args[0]})
-	if errr != nil {
-		return shim.Error(errr.Error())
+// parsePageArgs reads the page size and bookmark arguments of a paginated call
+func parsePageArgs(pageSizeArg string, bookmark string) (int32, string, error) {
+	pageSize, err := strconv.ParseInt(pageSizeArg, 10, 32)
+	if err != nil || pageSize <= 0 {
+		return 0, "", fmt.Errorf("Page size must be a positive number")
 	}
-	value := []byte{0x00}
-	APIstub.PutState(colorIndexKey, value)
-
-	return shim.Success(licenseAsBytes)
+	return int32(pageSize), bookmark, nil
 }
 
-func (S *SmartContract) queryComplainByLicenseNo(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+// LicensePage : one page of a paginated license list. Bookmark is passed to the
+// next call until it comes back empty.
+type LicensePage struct {
+	Records             []License `json:"records"`
+	FetchedRecordsCount int32     `json:"fetchedRecordsCount"`
+	Bookmark            string    `json:"bookmark"`
+}
 
-	if len(args) != 1 {
-		return shim.Error("Incorrect number of arguments")
+// queryStatusPage returns one page of the licenses listed in the index of the
+// given status. args: page size and optionally the bookmark of the previous page.
+func queryStatusPage(APIstub shim.ChaincodeStubInterface, status string, args []string) sc.Response {
+	if len(args) != 1 && len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 1 or 2")
 	}
-	licenseNo := args[0]
-
-	ownerAndIdResultIterator, err := APIstub.GetStateByPartialCompositeKey("crime~key", []string{licenseNo})
+	bookmark := ""
+	if len(args) == 2 {
+		bookmark = args[1]
+	}
+	pageSize, bookmark, err := parsePageArgs(args[0], bookmark)
 	if err != nil {
 		return shim.Error(err.Error())
 	}
 
-	defer ownerAndIdResultIterator.Close()
-
-	var i int
-	var id string
-
-	var licenses []byte
-	bArrayMemberAlreadyWritten := false
-
-	licenses = append([]byte("["))
+	resultsIterator, metadata, err := APIstub.GetStateByPartialCompositeKeyWithPagination(statusIndex[status], []string{"current"}, pageSize, bookmark)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
 
-	for i = 0; ownerAndIdResultIterator.HasNext(); i++ {
-		responseRange, err := ownerAndIdResultIterator.Next()
+	page := LicensePage{Records: []License{}}
+	for resultsIterator.HasNext() {
+		responseRange, err := resultsIterator.Next()
 		if err != nil {
 			return shim.Error(err.Error())
 		}
-
-		objectType, compositeKeyParts, err := APIstub.SplitCompositeKey(responseRange.Key)
+		_, compositeKeyParts, err := APIstub.SplitCompositeKey(responseRange.Key)
 		if err != nil {
 			return shim.Error(err.Error())
 		}
-
-		id = compositeKeyParts[1]
-		assetAsBytes, err := APIstub.GetState(id)
-
-		if bArrayMemberAlreadyWritten == true {
-			appendBytes := append([]byte(","), assetAsBytes...)
-			licenses = append(licenses, appendBytes...)
-
-		} else {
-			// newBytes := append([]byte(","), carsAsBytes...)
-			licenses = append(licenses, assetAsBytes...)
+		license, err := getLicense(APIstub, compositeKeyParts[1])
+		if err != nil {
+			return shim.Error(err.Error())
 		}
+		page.Records = append(page.Records, license)
+	}
+	page.FetchedRecordsCount = metadata.FetchedRecordsCount
+	page.Bookmark = metadata.Bookmark
+	if page.Records, err = withPII(APIstub, page.Records); err != nil {
+		return shim.Error(err.Error())
+	}
 
-		fmt.Printf("Found a asset for index : %s asset id : ", objectType, compositeKeyParts[0], compositeKeyParts[1])
-		bArrayMemberAlreadyWritten = true
-
+	pageAsBytes, err := json.Marshal(page)
+	if err != nil {
+		return shim.Error(err.Error())
 	}
 
-	licenses = append(licenses, []byte("]")...)
+	return shim.Success(pageAsBytes)
+}
 
-	return shim.Success(licenses)
+func (s *SmartContract) queryLearnerList(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+	return queryStatusPage(APIstub, StatusLearner, args)
 }
 
-func (S *SmartContract) queryActiveList(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+func (s *SmartContract) queryWaitingList(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+	return queryStatusPage(APIstub, StatusWaiting, args)
+}
 
-	if len(args) != 1 {
-		return shim.Error("Incorrect number of arguments")
-	}
-	current := "current"
+func (s *SmartContract) queryActiveList(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+	return queryStatusPage(APIstub, StatusActive, args)
+}
 
-	ownerAndIdResultIterator, err := APIstub.GetStateByPartialCompositeKey("active~key", []string{current})
-	if err != nil {
-		return shim.Error(err.Error())
-	}
+func (s *SmartContract) queryToStallList(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+	return queryStatusPage(APIstub, StatusToStall, args)
+}
 
-	defer ownerAndIdResultIterator.Close()
+func (s *SmartContract) queryStalledList(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+	return queryStatusPage(APIstub, StatusStalled, args)
+}
 
-	var i int
-	var id string
+// learnerLapsed reports whether a learner permit is past its validity at the given time.
+// Permits issued before learner validity was recorded never lapse.
+func learnerLapsed(license License, at time.Time) bool {
+	return permitLapsed(license.LearnerExpiryDate, at)
+}
 
-	var licenses []byte
-	bArrayMemberAlreadyWritten := false
+func permitLapsed(expiryDate *time.Time, at time.Time) bool {
+	return expiryDate != nil && !expiryDate.After(at)
+}
 
-	licenses = append([]byte("["))
+// statusIndexPage lists one page of the licenses in the index of a status for
+// invokes that write. Fabric only paginates queries in read-only transactions,
+// so the page is cut here and the bookmark is the index key the next page
+// starts at, as in migrateLedger.
+func statusIndexPage(APIstub shim.ChaincodeStubInterface, status string, pageSize int32, bookmark string) ([]string, string, error) {
+	resultsIterator, err := APIstub.GetStateByPartialCompositeKey(statusIndex[status], []string{"current"})
+	if err != nil {
+		return nil, "", err
+	}
+	defer resultsIterator.Close()
 
-	for i = 0; ownerAndIdResultIterator.HasNext(); i++ {
-		responseRange, err := ownerAndIdResultIterator.Next()
+	ids := []string{}
+	for resultsIterator.HasNext() {
+		responseRange, err := resultsIterator.Next()
 		if err != nil {
-			return shim.Error(err.Error())
+			return nil, "", err
 		}
-
-		objectType, compositeKeyParts, err := APIstub.SplitCompositeKey(responseRange.Key)
+		if responseRange.Key < bookmark {
+			continue
+		}
+		if int32(len(ids)) == pageSize {
+			return ids, responseRange.Key, nil
+		}
+		_, compositeKeyParts, err := APIstub.SplitCompositeKey(responseRange.Key)
 		if err != nil {
-			return shim.Error(err.Error())
+			return nil, "", err
 		}
+		ids = append(ids, compositeKeyParts[1])
+	}
 
-		id = compositeKeyParts[1]
-		assetAsBytes, err := APIstub.GetState(id)
-
-		// frac := "," + id
+	return ids, "", nil
+}
 
-		if bArrayMemberAlreadyWritten == true {
-			newBytes := append([]byte(","), assetAsBytes...)
-			licenses = append(licenses, newBytes...)
+// SweepResult : what one sweep call did. Bookmark is passed to the next call
+// until it comes back empty.
+type SweepResult struct {
+	Expired             []string `json:"expired"`
+	FetchedRecordsCount int32    `json:"fetchedRecordsCount"`
+	Bookmark            string   `json:"bookmark"`
+}
 
-		} else {
-			// newBytes := append([]byte(id), assetAsBytes...)
-			licenses = append(licenses, assetAsBytes...)
-		}
+// sweepExpiredLearners pages through learner~key and moves every lapsed learner
+// permit to Expired, clearing its test results. args: page size, bookmark.
+// The returned bookmark is passed to the next call until it comes back empty.
+func (s *SmartContract) sweepExpiredLearners(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+	pageSize, bookmark, err := parsePageArgs(args[0], args[1])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
 
-		fmt.Printf("Found a asset for index : %s asset id : ", objectType, compositeKeyParts[0], compositeKeyParts[1])
-		bArrayMemberAlreadyWritten = true
+	now, err := txTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
 
+	ids, next, err := statusIndexPage(APIstub, StatusLearner, pageSize, bookmark)
+	if err != nil {
+		return shim.Error(err.Error())
 	}
 
-	licenses = append(licenses, []byte("]")...)
+	result := SweepResult{Expired: []string{}, FetchedRecordsCount: int32(len(ids)), Bookmark: next}
+	for _, id := range ids {
+		license, err := getLicense(APIstub, id)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if !learnerLapsed(license, now) {
+			continue
+		}
 
-	return shim.Success(licenses)
-}
+		if err := setLicenseStatus(APIstub, &license, StatusExpired); err != nil {
+			return shim.Error(err.Error())
+		}
+		for _, id := range license.RequiredTests {
+			license.Tests[id] = false
+		}
+		if _, err := putLicense(APIstub, license); err != nil {
+			return shim.Error(err.Error())
+		}
+		result.Expired = append(result.Expired, license.ID)
+	}
 
-func (s *SmartContract) createPoliceReport(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
-	val, ok, err := cid.GetAttributeValue(APIstub, "role")
+	resultAsBytes, err := json.Marshal(result)
 	if err != nil {
-		// There was an error trying to retrieve the attribute
-		shim.Error("Error while retriving attributes")
+		return shim.Error(err.Error())
 	}
-	if !ok {
-		// The client identity does not possess the attribute
-		shim.Error("Client identity doesnot posses the attribute")
+
+	return shim.Success(resultAsBytes)
+}
+
+// sweepExpiredLicenses pages through active~key and moves every license past
+// its expiry date to Expired, from where renewLicense can reactivate it.
+// args: page size, bookmark. The returned bookmark is passed to the next call
+// until it comes back empty.
+func (s *SmartContract) sweepExpiredLicenses(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
 	}
-	// Do something with the value of 'val'
-	if val != "org2-police" {
-		fmt.Println("Attribute role: " + val)
-		return shim.Error("Only user with role as ORG2-Police have access this method!")
+	pageSize, bookmark, err := parsePageArgs(args[0], args[1])
+	if err != nil {
+		return shim.Error(err.Error())
 	}
 
-	if len(args) != 5 {
-		return shim.Error("Incorrect number of arguments. Expecting 5")
+	now, err := txTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
 	}
 
-	var trv = TrafficRuleViolatonReport{ID: args[0], Holder: args[1], Level: args[2], Desc: args[3], PointsDeduction: args[4]}
-
-	trvAsBytes, _ := json.Marshal(trv)
-	APIstub.PutState(args[0], trvAsBytes)
-
-	indexName := "crime~key"
-	colorNameIndexKey, err := APIstub.CreateCompositeKey(indexName, []string{args[1], args[0]})
+	ids, next, err := statusIndexPage(APIstub, StatusActive, pageSize, bookmark)
 	if err != nil {
 		return shim.Error(err.Error())
 	}
-	value := []byte{0x00}
-	APIstub.PutState(colorNameIndexKey, value)
 
-	pdeduce, errp := strconv.Atoi(args[4])
-	if errp != nil {
-		return shim.Error(errp.Error())
+	result := SweepResult{Expired: []string{}, FetchedRecordsCount: int32(len(ids)), Bookmark: next}
+	for _, id := range ids {
+		license, err := getLicense(APIstub, id)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if !permitLapsed(license.ExpiryDate, now) {
+			continue
+		}
+
+		if err := setLicenseStatus(APIstub, &license, StatusExpired); err != nil {
+			return shim.Error(err.Error())
+		}
+		if _, err := putLicense(APIstub, license); err != nil {
+			return shim.Error(err.Error())
+		}
+		result.Expired = append(result.Expired, license.ID)
 	}
 
-	licenseAsBytes, _ := APIstub.GetState(args[1])
-	license := License{}
+	resultAsBytes, err := json.Marshal(result)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
 
-	json.Unmarshal(licenseAsBytes, &license)
+	return shim.Success(resultAsBytes)
+}
 
-	premaining, errp2 := strconv.Atoi(license.Point)
-	if errp2 != nil {
-		return shim.Error(errp2.Error())
+// queryLapsingLearners returns the learners whose permit lapses within the given
+// number of days, including those already lapsed but not yet swept
+func (s *SmartContract) queryLapsingLearners(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+	window, err := strconv.Atoi(args[0])
+	if err != nil || window < 0 {
+		return shim.Error("Window must be a non-negative number of days")
 	}
 
-	pupdated := premaining - pdeduce
-
-	license.Point = strconv.Itoa(pupdated)
+	now, err := txTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
 
-	licenseAsBytes, _ = json.Marshal(license)
-	APIstub.PutState(args[1], licenseAsBytes)
+	learners, err := licensesInStatus(APIstub, StatusLearner)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
 
-	if pupdated <= 0 {
-		indexName5 := "tostall~key"
-		current5 := "current"
-		colorNameIndexKey5, err5 := APIstub.CreateCompositeKey(indexName5, []string{current5, args[1]})
-		if err5 != nil {
-			return shim.Error(err5.Error())
+	lapsing := []License{}
+	for _, license := range learners {
+		if learnerLapsed(license, now.Add(days(window))) {
+			lapsing = append(lapsing, license)
 		}
-		value5 := []byte{0x00}
-		APIstub.PutState(colorNameIndexKey5, value5)
 	}
 
-	return shim.Success(trvAsBytes)
-}
-
-func (S *SmartContract) queryWaitingList(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
-	val, ok, err := cid.GetAttributeValue(APIstub, "role")
+	lapsing, err = withPII(APIstub, lapsing)
 	if err != nil {
-		// There was an error trying to retrieve the attribute
-		shim.Error("Error while retriving attributes")
-	}
-	if !ok {
-		// The client identity does not possess the attribute
-		shim.Error("Client identity doesnot posses the attribute")
+		return shim.Error(err.Error())
 	}
-	// Do something with the value of 'val'
-	if val != "org1-approver" {
-		fmt.Println("Attribute role: " + val)
-		return shim.Error("Only user with role as ORG1-Approver have access this method!")
+	licensesAsBytes, err := json.Marshal(lapsing)
+	if err != nil {
+		return shim.Error(err.Error())
 	}
 
+	return shim.Success(licensesAsBytes)
+}
+
+func (S *SmartContract) queryComplainByLicenseNo(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
 	if len(args) != 1 {
 		return shim.Error("Incorrect number of arguments")
 	}
-	current := "current"
+	licenseNo := args[0]
 
-	ownerAndIdResultIterator, err := APIstub.GetStateByPartialCompositeKey("waiting~key", []string{current})
+	ownerAndIdResultIterator, err := APIstub.GetStateByPartialCompositeKey("crime~key", []string{licenseNo})
 	if err != nil {
 		return shim.Error(err.Error())
 	}
@@ -563,7 +965,7 @@ func (S *SmartContract) queryWaitingList(APIstub shim.ChaincodeStubInterface, ar
 	var licenses []byte
 	bArrayMemberAlreadyWritten := false
 
-	licenses = append([]byte("["))
+	licenses = []byte("[")
 
 	for i = 0; ownerAndIdResultIterator.HasNext(); i++ {
 		responseRange, err := ownerAndIdResultIterator.Next()
@@ -578,19 +980,20 @@ func (S *SmartContract) queryWaitingList(APIstub shim.ChaincodeStubInterface, ar
 
 		id = compositeKeyParts[1]
 		assetAsBytes, err := APIstub.GetState(id)
-
-		// frac := "," + id
+		if err != nil {
+			return shim.Error(err.Error())
+		}
 
 		if bArrayMemberAlreadyWritten == true {
-			newBytes := append([]byte(","), assetAsBytes...)
-			licenses = append(licenses, newBytes...)
+			appendBytes := append([]byte(","), assetAsBytes...)
+			licenses = append(licenses, appendBytes...)
 
 		} else {
-			// newBytes := append([]byte(id), assetAsBytes...)
+			// newBytes := append([]byte(","), carsAsBytes...)
 			licenses = append(licenses, assetAsBytes...)
 		}
 
-		fmt.Printf("Found a asset for index : %s asset id : ", objectType, compositeKeyParts[0], compositeKeyParts[1])
+		logger.Debugf("Found a asset for index : %s holder : %s asset id : %s", objectType, compositeKeyParts[0], compositeKeyParts[1])
 		bArrayMemberAlreadyWritten = true
 
 	}
@@ -600,200 +1003,359 @@ func (S *SmartContract) queryWaitingList(APIstub shim.ChaincodeStubInterface, ar
 	return shim.Success(licenses)
 }
 
-func (s *SmartContract) upgradeLearnerToActive(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
-	val, ok, err := cid.GetAttributeValue(APIstub, "role")
+// violationLevels : levels a traffic rule violation can be reported with
+var violationLevels = []string{"Low", "Medium", "High", "Critical"}
+
+// Error codes returned by createPoliceReport
+const (
+	ErrUnauthorized         = "UNAUTHORIZED"
+	ErrInvalidArguments     = "INVALID_ARGUMENTS"
+	ErrInvalidLevel         = "INVALID_LEVEL"
+	ErrInvalidDeduction     = "INVALID_DEDUCTION"
+	ErrLicenseNotFound      = "LICENSE_NOT_FOUND"
+	ErrLicenseNotReportable = "LICENSE_NOT_REPORTABLE"
+	ErrDuplicateReport      = "DUPLICATE_REPORT"
+	ErrLedger               = "LEDGER_ERROR"
+)
+
+// ReportError : structured error returned as the JSON message of a failed
+// createPoliceReport, so the police client can act on Code
+type ReportError struct {
+	Code    string `json:"code"`
+	Field   string `json:"field,omitempty"`
+	Message string `json:"message"`
+}
+
+func reportError(code string, field string, message string) sc.Response {
+	errAsBytes, err := json.Marshal(ReportError{Code: code, Field: field, Message: message})
 	if err != nil {
-		// There was an error trying to retrieve the attribute
-		shim.Error("Error while retriving attributes")
+		return shim.Error(message)
 	}
-	if !ok {
-		// The client identity does not possess the attribute
-		shim.Error("Client identity doesnot posses the attribute")
+	return shim.Error(string(errAsBytes))
+}
+
+// createPoliceReport files a violation report against an Active or ToStall
+// license and deducts its points. Every argument and the license are checked
+// before anything is written. args: report ID, license ID, level, description,
+// points deduction.
+func (s *SmartContract) createPoliceReport(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) != 5 {
+		return reportError(ErrInvalidArguments, "", "Incorrect number of arguments. Expecting 5")
 	}
-	// Do something with the value of 'val'
-	if val != "org1-approver" {
-		fmt.Println("Attribute role: " + val)
-		return shim.Error("Only user with role as ORG1-Approver have access this method!")
+	if args[0] == "" {
+		return reportError(ErrInvalidArguments, "id", "Report ID is required")
 	}
-
-	if len(args) != 2 {
-		return shim.Error("Incorrect number of arguments. Expecting 2")
+	if args[1] == "" {
+		return reportError(ErrInvalidArguments, "holder", "License ID is required")
 	}
 
-	licenseAsBytes, _ := APIstub.GetState(args[0])
-	license := License{}
+	validLevel := false
+	for _, level := range violationLevels {
+		if args[2] == level {
+			validLevel = true
+		}
+	}
+	if !validLevel {
+		return reportError(ErrInvalidLevel, "level", "Level must be one of "+strings.Join(violationLevels, ", "))
+	}
 
-	json.Unmarshal(licenseAsBytes, &license)
+	pdeduce, err := strconv.Atoi(args[4])
+	if err != nil || pdeduce <= 0 || pdeduce > InitialPoints {
+		return reportError(ErrInvalidDeduction, "pointsdeduction", "Points deduction must be a number between 1 and "+strconv.Itoa(InitialPoints))
+	}
 
-	indexName := "learner~key"
-	current := "current"
-	colorNameIndexKey, err := APIstub.CreateCompositeKey(indexName, []string{current, args[0]})
+	existing, err := APIstub.GetState(args[0])
 	if err != nil {
-		return shim.Error(err.Error())
+		return reportError(ErrLedger, "id", err.Error())
+	}
+	if existing != nil {
+		return reportError(ErrDuplicateReport, "id", "Key "+args[0]+" already exists")
 	}
 
-	APIstub.DelState(colorNameIndexKey)
-
-	indexName = "waiting~key"
-	current = "current"
-	colorNameIndexKey, err = APIstub.CreateCompositeKey(indexName, []string{current, args[0]})
+	license, err := getLicense(APIstub, args[1])
 	if err != nil {
-		return shim.Error(err.Error())
+		return reportError(ErrLicenseNotFound, "holder", err.Error())
 	}
-
-	APIstub.DelState(colorNameIndexKey)
-
-	license.Status = "Active"
-
-	licenseAsBytes, _ = json.Marshal(license)
-	APIstub.PutState(args[0], licenseAsBytes)
-
-	indexName = "active~key"
-
-	colorIndexKey, errr := APIstub.CreateCompositeKey(indexName, []string{current, args[0]})
-	if errr != nil {
-		return shim.Error(errr.Error())
+	if license.Status != StatusActive && license.Status != StatusToStall {
+		return reportError(ErrLicenseNotReportable, "holder", "License "+license.ID+" is "+license.Status+", only Active or ToStall licenses can be reported")
 	}
-	value := []byte{0x00}
-	APIstub.PutState(colorIndexKey, value)
-
-	return shim.Success(licenseAsBytes)
-}
 
-func (s *SmartContract) queryLicense(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
-
-	if len(args) != 1 {
-		return shim.Error("Incorrect number of arguments. Expecting 1")
+	now, err := txTime(APIstub)
+	if err != nil {
+		return reportError(ErrLedger, "", err.Error())
 	}
 
-	licenseAsBytes, _ := APIstub.GetState(args[0])
-	return shim.Success(licenseAsBytes)
-}
+	// settle the points earned back so far, before this report restarts the
+	// violation-free period
+	if _, err := restorePoints(APIstub, &license, now); err != nil {
+		return reportError(ErrLedger, "", err.Error())
+	}
 
-func (s *SmartContract) initLedger(APIstub shim.ChaincodeStubInterface) sc.Response {
-	licenses := []License{
-		License{ID: "LICENSE0", Name: "Test1", NID: "123", Status: "Active", Test1: "Yes", Test2: "Yes", Test3: "Yes", Point: "15"},
-		License{ID: "LICENSE1", Name: "Test1", NID: "123", Status: "Active", Test1: "Yes", Test2: "Yes", Test3: "Yes", Point: "15"},
+	pupdated := license.Point - pdeduce
+	license.Point = pupdated
+	if err := applyClassThresholds(APIstub, &license); err != nil {
+		return reportError(ErrLedger, "", err.Error())
 	}
+	emit(APIstub, Event{Type: EventViolationReported, LicenseID: license.ID, ReportID: args[0], Level: args[2], Points: &pupdated})
 
-	i := 0
-	for i < len(licenses) {
-		licenseAsBytes, _ := json.Marshal(licenses[i])
-		APIstub.PutState("LICENSE"+strconv.Itoa(i), licenseAsBytes)
-		i = i + 1
+	if pupdated <= 0 && license.Status == StatusActive {
+		if err := setLicenseStatus(APIstub, &license, StatusToStall); err != nil {
+			return reportError(ErrLedger, "", err.Error())
+		}
 	}
 
-	return shim.Success(nil)
-}
+	var trv = TrafficRuleViolatonReport{ID: args[0], Holder: args[1], Level: args[2], Desc: args[3], PointsDeduction: pdeduce, Date: timestamp(now), Status: ReportFiled}
 
-func (s *SmartContract) createLearnerLicense(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
-	val, ok, err := cid.GetAttributeValue(APIstub, "role")
+	trvAsBytes, err := putReport(APIstub, trv)
 	if err != nil {
-		// There was an error trying to retrieve the attribute
-		shim.Error("Error while retriving attributes")
+		return reportError(ErrLedger, "", err.Error())
 	}
-	if !ok {
-		// The client identity does not possess the attribute
-		shim.Error("Client identity doesnot posses the attribute")
+
+	crimeIndexKey, err := APIstub.CreateCompositeKey("crime~key", []string{args[1], args[0]})
+	if err != nil {
+		return reportError(ErrLedger, "", err.Error())
+	}
+	if err := APIstub.PutState(crimeIndexKey, []byte{0x00}); err != nil {
+		return reportError(ErrLedger, "", err.Error())
 	}
-	// Do something with the value of 'val'
-	if val != "org1-approver" {
-		fmt.Println("Attribute role: " + val)
-		return shim.Error("Only user with role as ORG1-Approver have access this method!")
+	if _, err := requireReportEndorsers(APIstub, trv); err != nil {
+		return reportError(ErrLedger, "", err.Error())
 	}
 
-	if len(args) != 4 {
-		return shim.Error("Incorrect number of arguments. Expecting 4")
+	if _, err := putLicense(APIstub, license); err != nil {
+		return reportError(ErrLedger, "", err.Error())
 	}
 
-	keyExists, err := APIstub.GetState(args[0])
-	if keyExists != nil {
-		return shim.Error("Key already exists")
+	return shim.Success(trvAsBytes)
+}
+
+func (s *SmartContract) upgradeLearnerToActive(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
 	}
 
-	nidExist, errn := APIstub.CreateCompositeKey("nid~key", []string{"current", args[2]})
-	if errn != nil {
-		return shim.Error(errn.Error())
+	license, err := getLicense(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
 	}
 
-	keyExists, err = APIstub.GetState(nidExist)
-	if keyExists != nil {
-		return shim.Error("NID already exists")
+	// only licenses that passed every test may be upgraded, not ones coming
+	// back from any other status
+	if license.Status != StatusWaiting {
+		return shim.Error("License " + license.ID + " is " + license.Status + ", only Waiting licenses can be upgraded to Active")
 	}
 
-	var license = License{ID: args[0], Name: args[1], NID: args[2], Status: "Learner", Test1: "No", Test2: "No", Test3: "No", Point: "15"}
+	config, err := getConfig(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	now, err := txTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
 
-	licenseAsBytes, _ := json.Marshal(license)
-	APIstub.PutState(args[0], licenseAsBytes)
+	if err := setLicenseStatus(APIstub, &license, StatusActive); err != nil {
+		return shim.Error(err.Error())
+	}
+	license.Classes = []string{license.Class}
+	license.IssueDate = timestamp(now)
+	license.ExpiryDate = timestamp(now.AddDate(config.LicenseValidityYears, 0, 0))
 
-	indexName := "learner~key"
-	current := "current"
-	colorNameIndexKey, err := APIstub.CreateCompositeKey(indexName, []string{current, args[0]})
+	licenseAsBytes, err := putLicense(APIstub, license)
 	if err != nil {
 		return shim.Error(err.Error())
 	}
-	value := []byte{0x00}
-	APIstub.PutState(colorNameIndexKey, value)
-	APIstub.PutState(nidExist, value)
 
 	return shim.Success(licenseAsBytes)
 }
 
-func (S *SmartContract) queryLearnerList(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+func (s *SmartContract) queryLicense(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
 
 	if len(args) != 1 {
-		return shim.Error("Incorrect number of arguments")
+		return shim.Error("Incorrect number of arguments. Expecting 1")
 	}
-	current := "current"
 
-	ownerAndIdResultIterator, err := APIstub.GetStateByPartialCompositeKey("learner~key", []string{current})
+	license, err := getLicense(APIstub, args[0])
 	if err != nil {
 		return shim.Error(err.Error())
 	}
 
-	defer ownerAndIdResultIterator.Close()
+	// show the points as they stand now, the restoration is only written
+	// by the next recalculatePoints or police report
+	now, err := txTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if _, err := projectPoints(APIstub, &license, now); err != nil {
+		return shim.Error(err.Error())
+	}
+	if canReadPII(APIstub) {
+		if err := loadPII(APIstub, &license); err != nil {
+			return shim.Error(err.Error())
+		}
+	}
 
-	var i int
-	var id string
+	licenseAsBytes, err := json.Marshal(license)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(licenseAsBytes)
+}
 
-	var licenses []byte
-	bArrayMemberAlreadyWritten := false
+// initLedger creates two sample Active licenses on a fresh network. They carry
+// no personal data, which only createLearnerLicense takes, and go through the
+// same status transitions as any other license so their indexes and
+// endorsement policies are set up the usual way.
+func (s *SmartContract) initLedger(APIstub shim.ChaincodeStubInterface) sc.Response {
+	ids := []string{"LICENSE0", "LICENSE1"}
+	for _, id := range ids {
+		existing, err := APIstub.GetState(id)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if existing != nil {
+			return shim.Error("Key " + id + " already exists, the ledger is already initialized")
+		}
+	}
+
+	config, err := getConfig(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	now, err := txTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
 
-	licenses = append([]byte("["))
+	for _, id := range ids {
+		license := License{ID: id, Status: StatusLearner, Point: InitialPoints}
+		license.Class = DefaultClass
+		license.Classes = []string{}
+		license.SuspendedClasses = []string{}
+		license.Endorsements = map[string]Endorsement{}
+		license.RequiredTests = legacyTests
+		license.Tests = TestResults{}
+		for _, test := range legacyTests {
+			license.Tests[test] = true
+		}
 
-	for i = 0; ownerAndIdResultIterator.HasNext(); i++ {
-		responseRange, err := ownerAndIdResultIterator.Next()
-		if err != nil {
+		if err := putStatusIndex(APIstub, license.Status, license.ID); err != nil {
 			return shim.Error(err.Error())
 		}
+		emit(APIstub, Event{Type: EventLicenseCreated, LicenseID: license.ID, ToStatus: license.Status, Class: license.Class})
+		for _, status := range []string{StatusWaiting, StatusActive} {
+			if err := setLicenseStatus(APIstub, &license, status); err != nil {
+				return shim.Error(err.Error())
+			}
+		}
+		license.Classes = []string{license.Class}
+		license.IssueDate = timestamp(now)
+		license.ExpiryDate = timestamp(now.AddDate(config.LicenseValidityYears, 0, 0))
 
-		objectType, compositeKeyParts, err := APIstub.SplitCompositeKey(responseRange.Key)
-		if err != nil {
+		if _, err := putLicense(APIstub, license); err != nil {
 			return shim.Error(err.Error())
 		}
+	}
 
-		id = compositeKeyParts[1]
-		assetAsBytes, err := APIstub.GetState(id)
+	return shim.Success(nil)
+}
 
-		// frac := "," + id
+// createLearnerLicense issues a learner license. args: license ID, optionally
+// a license class; the holder's name, NID and date of birth are passed in the
+// transient map (PIITransientKey) with a fresh salt so they are not stored with
+// the transaction, together with the NID key (NIDKeyTransientKey).
+func (s *SmartContract) createLearnerLicense(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) == 4 || len(args) == 5 {
+		return shim.Error("Name, NID and date of birth are no longer accepted as arguments, pass them in the transient map under \"" + PIITransientKey + "\"")
+	}
+	if len(args) != 1 && len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 1 or 2")
+	}
+	classID := DefaultClass
+	if len(args) == 2 {
+		classID = args[1]
+	}
 
-		if bArrayMemberAlreadyWritten == true {
-			newBytes := append([]byte(","), assetAsBytes...)
-			licenses = append(licenses, newBytes...)
+	pii, err := transientPII(APIstub, "name", "nid", "dob", "salt")
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := rejectPIIArgs(args, pii); err != nil {
+		return shim.Error(err.Error())
+	}
+	key, err := transientNIDKey(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
 
-		} else {
-			// newBytes := append([]byte(id), assetAsBytes...)
-			licenses = append(licenses, assetAsBytes...)
-		}
+	keyExists, err := APIstub.GetState(args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if keyExists != nil {
+		return shim.Error("Key already exists")
+	}
 
-		fmt.Printf("Found a asset for index : %s asset id : ", objectType, compositeKeyParts[0], compositeKeyParts[1])
-		bArrayMemberAlreadyWritten = true
+	nidOwner, err := licenseIDForNID(APIstub, key, pii.NID)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if nidOwner != "" {
+		return shim.Error("NID already exists")
+	}
 
+	config, err := getConfig(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	now, err := txTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
 	}
 
-	licenses = append(licenses, []byte("]")...)
+	classes, err := getLicenseClasses(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	class, ok := classes[classID]
+	if !ok {
+		return shim.Error("Unknown license class " + classID)
+	}
+	if err := checkMinAge(pii.DOB, class, now); err != nil {
+		return shim.Error(err.Error())
+	}
+	required, err := classTests(APIstub, class)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
 
-	return shim.Success(licenses)
+	var license = License{ID: args[0], Status: StatusLearner, Point: InitialPoints}
+	license.Class = class.ID
+	license.Classes = []string{}
+	license.SuspendedClasses = []string{}
+	license.Endorsements = map[string]Endorsement{}
+	license.RequiredTests = required
+	license.Tests = TestResults{}
+	for _, id := range required {
+		license.Tests[id] = false
+	}
+	license.LearnerExpiryDate = timestamp(now.Add(days(config.LearnerValidityDays)))
+
+	holder := HolderPII{LicenseID: license.ID, Name: pii.Name, NID: pii.NID, DOB: pii.DOB, Salt: pii.Salt}
+	if err := setPII(APIstub, &license, holder, key); err != nil {
+		return shim.Error(err.Error())
+	}
+	licenseAsBytes, err := putLicense(APIstub, license)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	if err := putStatusIndex(APIstub, license.Status, license.ID); err != nil {
+		return shim.Error(err.Error())
+	}
+	emit(APIstub, Event{Type: EventLicenseCreated, LicenseID: license.ID, ToStatus: license.Status, Class: license.Class})
+
+	return shim.Success(licenseAsBytes)
 }
 
 func (s *SmartContract) restictedMethod(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
@@ -810,159 +1372,49 @@ func (s *SmartContract) restictedMethod(APIstub shim.ChaincodeStubInterface, arg
 	// get the X509 certificate of the client, or nil if the client's identity was not based on an X509 certificate
 	//cert, err := cid.GetX509Certificate(APIstub) -
 
-	val, ok, err := cid.GetAttributeValue(APIstub, "role")
-	if err != nil {
-		// There was an error trying to retrieve the attribute
-		shim.Error("Error while retriving attributes")
-	}
-	if !ok {
-		// The client identity does not possess the attribute
-		shim.Error("Client identity doesnot posses the attribute")
-	}
-	// Do something with the value of 'val'
-	if val != "org1-approver" {
-		fmt.Println("Attribute role: " + val)
-		return shim.Error("Only user with role as ORG1 have access this method!")
-	}
 	if len(args) != 1 {
 		return shim.Error("Incorrect number of arguments. Expecting 1")
 	}
 
-	carAsBytes, _ := APIstub.GetState(args[0])
+	carAsBytes, err := APIstub.GetState(args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
 	return shim.Success(carAsBytes)
 
 }
 
+// inputTest1Result records the first test, kept for clients written before
+// recordTestResult. args: license ID, Yes|No
 func (s *SmartContract) inputTest1Result(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
-	val, ok, err := cid.GetAttributeValue(APIstub, "role")
-	if err != nil {
-		// There was an error trying to retrieve the attribute
-		shim.Error("Error while retriving attributes")
-	}
-	if !ok {
-		// The client identity does not possess the attribute
-		shim.Error("Client identity doesnot posses the attribute")
-	}
-	// Do something with the value of 'val'
-	if val != "org1-examcenter1" {
-		fmt.Println("Attribute role: " + val)
-		return shim.Error("Only user with role as ORG1-ExamCenter1 have access this method!")
-	}
 
 	if len(args) != 2 {
 		return shim.Error("Incorrect number of arguments. Expecting 2")
 	}
 
-	licenseAsBytes, _ := APIstub.GetState(args[0])
-	license := License{}
-
-	json.Unmarshal(licenseAsBytes, &license)
-
-	license.Test1 = args[1]
-
-	licenseAsBytes, _ = json.Marshal(license)
-	APIstub.PutState(args[0], licenseAsBytes)
-
-	if license.Test1 == "Yes" && license.Test2 == "Yes" && license.Test3 == "Yes" {
-		indexName := "waiting~key"
-		current := "current"
-		colorNameIndexKey, err := APIstub.CreateCompositeKey(indexName, []string{current, args[0]})
-		if err != nil {
-			return shim.Error(err.Error())
-		}
-		value := []byte{0x00}
-		APIstub.PutState(colorNameIndexKey, value)
-	}
-
-	return shim.Success(licenseAsBytes)
+	return recordLegacyTest(APIstub, args[0], "test1", args[1])
 }
 
+// inputTest2Result records the second test, kept for clients written before
+// recordTestResult. args: license ID, Yes|No
 func (s *SmartContract) inputTest2Result(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
-	val, ok, err := cid.GetAttributeValue(APIstub, "role")
-	if err != nil {
-		// There was an error trying to retrieve the attribute
-		shim.Error("Error while retriving attributes")
-	}
-	if !ok {
-		// The client identity does not possess the attribute
-		shim.Error("Client identity doesnot posses the attribute")
-	}
-	// Do something with the value of 'val'
-	if val != "org1-examcenter2" {
-		fmt.Println("Attribute role: " + val)
-		return shim.Error("Only user with role as ORG1-ExamCenter2 have access this method!")
-	}
 
 	if len(args) != 2 {
 		return shim.Error("Incorrect number of arguments. Expecting 2")
 	}
 
-	licenseAsBytes, _ := APIstub.GetState(args[0])
-	license := License{}
-
-	json.Unmarshal(licenseAsBytes, &license)
-
-	license.Test2 = args[1]
-
-	licenseAsBytes, _ = json.Marshal(license)
-	APIstub.PutState(args[0], licenseAsBytes)
-
-	if license.Test1 == "Yes" && license.Test2 == "Yes" && license.Test3 == "Yes" {
-		indexName := "waiting~key"
-		current := "current"
-		colorNameIndexKey, err := APIstub.CreateCompositeKey(indexName, []string{current, args[0]})
-		if err != nil {
-			return shim.Error(err.Error())
-		}
-		value := []byte{0x00}
-		APIstub.PutState(colorNameIndexKey, value)
-	}
-
-	return shim.Success(licenseAsBytes)
+	return recordLegacyTest(APIstub, args[0], "test2", args[1])
 }
 
+// inputTest3Result records the third test, kept for clients written before
+// recordTestResult. args: license ID, Yes|No
 func (s *SmartContract) inputTest3Result(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
-	val, ok, err := cid.GetAttributeValue(APIstub, "role")
-	if err != nil {
-		// There was an error trying to retrieve the attribute
-		shim.Error("Error while retriving attributes")
-	}
-	if !ok {
-		// The client identity does not possess the attribute
-		shim.Error("Client identity doesnot posses the attribute")
-	}
-	// Do something with the value of 'val'
-	if val != "org1-examcenter3" {
-		fmt.Println("Attribute role: " + val)
-		return shim.Error("Only user with role as ORG1-ExamCenter3 have access this method!")
-	}
 
 	if len(args) != 2 {
 		return shim.Error("Incorrect number of arguments. Expecting 2")
 	}
 
-	licenseAsBytes, _ := APIstub.GetState(args[0])
-	license := License{}
-
-	json.Unmarshal(licenseAsBytes, &license)
-
-	license.Test3 = args[1]
-
-	licenseAsBytes, _ = json.Marshal(license)
-	APIstub.PutState(args[0], licenseAsBytes)
-
-	if license.Test1 == "Yes" && license.Test2 == "Yes" && license.Test3 == "Yes" {
-		indexName := "waiting~key"
-		current := "current"
-		colorNameIndexKey, err := APIstub.CreateCompositeKey(indexName, []string{current, args[0]})
-		if err != nil {
-			return shim.Error(err.Error())
-		}
-		value := []byte{0x00}
-		APIstub.PutState(colorNameIndexKey, value)
-	}
-
-	return shim.Success(licenseAsBytes)
+	return recordLegacyTest(APIstub, args[0], "test3", args[1])
 }
 
 func (t *SmartContract) getHistoryForAsset(stub shim.ChaincodeStubInterface, args []string) sc.Response {
@@ -1023,7 +1475,8 @@ func (t *SmartContract) getHistoryForAsset(stub shim.ChaincodeStubInterface, arg
 	}
 	buffer.WriteString("]")
 
-	fmt.Printf("- getHistoryForAsset returning:\n%s\n", buffer.String())
+	// the values may hold personal data, which stays out of the peer log
+	logger.Debugf("- getHistoryForAsset returning the history of %s", licenseID)
 
 	return shim.Success(buffer.Bytes())
 }
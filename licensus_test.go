@@ -0,0 +1,865 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	tspb "github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+	"github.com/hyperledger/fabric-protos-go/msp"
+	sc "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+// ledger : in-memory world state, private data and key-level endorsement
+// policies, changed only by the transactions invoke commits
+type ledger struct {
+	state   map[string][]byte
+	private map[string][]byte
+	params  map[string][]byte
+	now     time.Time
+	txs     int
+}
+
+func newLedger() *ledger {
+	return &ledger{
+		state:   map[string][]byte{},
+		private: map[string][]byte{},
+		params:  map[string][]byte{},
+		now:     time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC),
+	}
+}
+
+func copyValues(values map[string][]byte) map[string][]byte {
+	copied := map[string][]byte{}
+	for key, value := range values {
+		copied[key] = append([]byte{}, value...)
+	}
+	return copied
+}
+
+func (l *ledger) clone() *ledger {
+	return &ledger{state: copyValues(l.state), private: copyValues(l.private), params: copyValues(l.params), now: l.now, txs: l.txs}
+}
+
+func sameValues(a, b map[string][]byte) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for key, value := range a {
+		other, ok := b[key]
+		if !ok || !bytes.Equal(value, other) {
+			return false
+		}
+	}
+	return true
+}
+
+// equal reports whether two ledgers hold byte for byte the same data
+func (l *ledger) equal(other *ledger) bool {
+	return sameValues(l.state, other.state) && sameValues(l.private, other.private) && sameValues(l.params, other.params)
+}
+
+// write : one write a transaction made, in the order it made them
+type write struct {
+	collection string
+	key        string
+	value      []byte
+	delete     bool
+	param      bool
+}
+
+// txStub : ChaincodeStubInterface of one transaction. Reads see the ledger as
+// committed, as on a peer, and writes are recorded until invoke commits them,
+// which it only does for a successful response: Fabric discards the write set
+// of a failed transaction. Every call reaching the ledger or the identity of
+// the caller is counted, and the failAt-th one fails.
+type txStub struct {
+	shim.ChaincodeStubInterface
+
+	ledger    *ledger
+	txID      string
+	function  string
+	args      []string
+	creator   []byte
+	transient map[string][]byte
+
+	writes []write
+	events map[string][]byte
+
+	calls  int
+	failAt int
+}
+
+var errInjected = errors.New("injected failure")
+
+func (stub *txStub) call() error {
+	stub.calls++
+	if stub.calls == stub.failAt {
+		return errInjected
+	}
+	return nil
+}
+
+func (stub *txStub) GetFunctionAndParameters() (string, []string) {
+	return stub.function, stub.args
+}
+
+func (stub *txStub) GetTxID() string {
+	return stub.txID
+}
+
+func (stub *txStub) GetState(key string) ([]byte, error) {
+	if err := stub.call(); err != nil {
+		return nil, err
+	}
+	return stub.ledger.state[key], nil
+}
+
+func (stub *txStub) PutState(key string, value []byte) error {
+	if err := stub.call(); err != nil {
+		return err
+	}
+	stub.writes = append(stub.writes, write{key: key, value: value})
+	return nil
+}
+
+func (stub *txStub) DelState(key string) error {
+	if err := stub.call(); err != nil {
+		return err
+	}
+	stub.writes = append(stub.writes, write{key: key, delete: true})
+	return nil
+}
+
+func (stub *txStub) GetStateValidationParameter(key string) ([]byte, error) {
+	if err := stub.call(); err != nil {
+		return nil, err
+	}
+	return stub.ledger.params[key], nil
+}
+
+func (stub *txStub) SetStateValidationParameter(key string, ep []byte) error {
+	if err := stub.call(); err != nil {
+		return err
+	}
+	stub.writes = append(stub.writes, write{key: key, value: ep, param: true})
+	return nil
+}
+
+func (stub *txStub) CreateCompositeKey(objectType string, attributes []string) (string, error) {
+	if err := stub.call(); err != nil {
+		return "", err
+	}
+	key := "\x00" + objectType + "\x00"
+	for _, attribute := range attributes {
+		key += attribute + "\x00"
+	}
+	return key, nil
+}
+
+func (stub *txStub) SplitCompositeKey(compositeKey string) (string, []string, error) {
+	if err := stub.call(); err != nil {
+		return "", nil, err
+	}
+	parts := strings.Split(strings.TrimSuffix(strings.TrimPrefix(compositeKey, "\x00"), "\x00"), "\x00")
+	return parts[0], parts[1:], nil
+}
+
+// iterator returns the committed keys accepted by match, in key order
+func (stub *txStub) iterator(match func(key string) bool) *kvIterator {
+	keys := []string{}
+	for key := range stub.ledger.state {
+		if match(key) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	iterator := &kvIterator{stub: stub}
+	for _, key := range keys {
+		iterator.results = append(iterator.results, &queryresult.KV{Key: key, Value: stub.ledger.state[key]})
+	}
+	return iterator
+}
+
+func (stub *txStub) GetStateByRange(startKey, endKey string) (shim.StateQueryIteratorInterface, error) {
+	if err := stub.call(); err != nil {
+		return nil, err
+	}
+	// range queries never return composite keys
+	return stub.iterator(func(key string) bool {
+		return !strings.HasPrefix(key, "\x00") && key >= startKey && (endKey == "" || key < endKey)
+	}), nil
+}
+
+func (stub *txStub) GetStateByPartialCompositeKey(objectType string, keys []string) (shim.StateQueryIteratorInterface, error) {
+	prefix, err := stub.CreateCompositeKey(objectType, keys)
+	if err != nil {
+		return nil, err
+	}
+	return stub.iterator(func(key string) bool {
+		return strings.HasPrefix(key, prefix)
+	}), nil
+}
+
+// GetStateByPartialCompositeKeyWithPagination pages like the LevelDB state
+// database: the bookmark is the key the next page starts at, "" after the last
+func (stub *txStub) GetStateByPartialCompositeKeyWithPagination(objectType string, keys []string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *sc.QueryResponseMetadata, error) {
+	prefix, err := stub.CreateCompositeKey(objectType, keys)
+	if err != nil {
+		return nil, nil, err
+	}
+	iterator := stub.iterator(func(key string) bool {
+		return strings.HasPrefix(key, prefix) && key >= bookmark
+	})
+	metadata := &sc.QueryResponseMetadata{}
+	if int32(len(iterator.results)) > pageSize {
+		metadata.Bookmark = iterator.results[pageSize].Key
+		iterator.results = iterator.results[:pageSize]
+	}
+	metadata.FetchedRecordsCount = int32(len(iterator.results))
+	return iterator, metadata, nil
+}
+
+func (stub *txStub) GetPrivateData(collection, key string) ([]byte, error) {
+	if err := stub.call(); err != nil {
+		return nil, err
+	}
+	return stub.ledger.private[collection+"/"+key], nil
+}
+
+func (stub *txStub) GetPrivateDataHash(collection, key string) ([]byte, error) {
+	if err := stub.call(); err != nil {
+		return nil, err
+	}
+	value, ok := stub.ledger.private[collection+"/"+key]
+	if !ok {
+		return nil, nil
+	}
+	sum := sha256.Sum256(value)
+	return sum[:], nil
+}
+
+func (stub *txStub) PutPrivateData(collection string, key string, value []byte) error {
+	if err := stub.call(); err != nil {
+		return err
+	}
+	stub.writes = append(stub.writes, write{collection: collection, key: key, value: value})
+	return nil
+}
+
+func (stub *txStub) DelPrivateData(collection, key string) error {
+	if err := stub.call(); err != nil {
+		return err
+	}
+	stub.writes = append(stub.writes, write{collection: collection, key: key, delete: true})
+	return nil
+}
+
+func (stub *txStub) PurgePrivateData(collection, key string) error {
+	return stub.DelPrivateData(collection, key)
+}
+
+func (stub *txStub) GetTransient() (map[string][]byte, error) {
+	if err := stub.call(); err != nil {
+		return nil, err
+	}
+	return stub.transient, nil
+}
+
+func (stub *txStub) GetTxTimestamp() (*tspb.Timestamp, error) {
+	if err := stub.call(); err != nil {
+		return nil, err
+	}
+	return &tspb.Timestamp{Seconds: stub.ledger.now.Unix(), Nanos: int32(stub.ledger.now.Nanosecond())}, nil
+}
+
+func (stub *txStub) SetEvent(name string, payload []byte) error {
+	if err := stub.call(); err != nil {
+		return err
+	}
+	stub.events[name] = payload
+	return nil
+}
+
+func (stub *txStub) GetCreator() ([]byte, error) {
+	if err := stub.call(); err != nil {
+		return nil, err
+	}
+	return stub.creator, nil
+}
+
+// commit applies the recorded writes to the ledger
+func (stub *txStub) commit() {
+	for _, w := range stub.writes {
+		values, key := stub.ledger.state, w.key
+		if w.param {
+			values = stub.ledger.params
+		} else if w.collection != "" {
+			values, key = stub.ledger.private, w.collection+"/"+w.key
+		}
+		if w.delete {
+			delete(values, key)
+			if w.collection == "" {
+				delete(stub.ledger.params, key)
+			}
+			continue
+		}
+		values[key] = append([]byte{}, w.value...)
+	}
+}
+
+type kvIterator struct {
+	stub    *txStub
+	results []*queryresult.KV
+}
+
+func (iterator *kvIterator) HasNext() bool {
+	return len(iterator.results) > 0
+}
+
+func (iterator *kvIterator) Next() (*queryresult.KV, error) {
+	if err := iterator.stub.call(); err != nil {
+		return nil, err
+	}
+	next := iterator.results[0]
+	iterator.results = iterator.results[1:]
+	return next, nil
+}
+
+func (iterator *kvIterator) Close() error {
+	return nil
+}
+
+// caller : the client identity and transient data of a transaction
+type caller struct {
+	creator   []byte
+	transient map[string][]byte
+}
+
+var identities = map[string][]byte{}
+
+// identity returns the serialized identity of a client of an MSP whose
+// enrollment certificate carries the given role attribute
+func identity(t *testing.T, mspID string, role string) []byte {
+	t.Helper()
+	if creator, ok := identities[mspID+"/"+role]; ok {
+		return creator
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(int64(len(identities) + 1)),
+		Subject:      pkix.Name{CommonName: role + "@" + mspID, Organization: []string{mspID}},
+		NotBefore:    time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+		NotAfter:     time.Date(2040, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+	attrs, err := json.Marshal(map[string]map[string]string{"attrs": {"role": role}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	template.ExtraExtensions = []pkix.Extension{{Id: asn1.ObjectIdentifier{1, 2, 3, 4, 5, 6, 7, 8, 1}, Value: attrs}}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	creator, err := proto.Marshal(&msp.SerializedIdentity{Mspid: mspID, IdBytes: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})})
+	if err != nil {
+		t.Fatal(err)
+	}
+	identities[mspID+"/"+role] = creator
+	return creator
+}
+
+func org1(t *testing.T, role string) caller {
+	return caller{creator: identity(t, "Org1MSP", role)}
+}
+
+func org2(t *testing.T, role string) caller {
+	return caller{creator: identity(t, "Org2MSP", role)}
+}
+
+// with returns the caller passing the given transient data, each value
+// encoded as JSON
+func (c caller) with(t *testing.T, transient map[string]interface{}) caller {
+	t.Helper()
+	c.transient = map[string][]byte{}
+	for key, value := range transient {
+		valueAsBytes, err := json.Marshal(value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.transient[key] = valueAsBytes
+	}
+	return c
+}
+
+// transaction prepares a transaction failing its failAt-th ledger or identity
+// call, 0 for none
+func (l *ledger) transaction(c caller, failAt int, function string, args ...string) *txStub {
+	l.txs++
+	return &txStub{
+		ledger:    l,
+		txID:      "tx" + strconv.Itoa(l.txs),
+		function:  function,
+		args:      args,
+		creator:   c.creator,
+		transient: c.transient,
+		events:    map[string][]byte{},
+		failAt:    failAt,
+	}
+}
+
+// invoke runs a transaction and commits its writes if it succeeds
+func (l *ledger) invoke(c caller, function string, args ...string) (sc.Response, *txStub) {
+	stub := l.transaction(c, 0, function, args...)
+	response := new(SmartContract).Invoke(stub)
+	if response.Status == shim.OK {
+		stub.commit()
+	}
+	return response, stub
+}
+
+// mustInvoke runs a transaction that has to succeed
+func (l *ledger) mustInvoke(t *testing.T, c caller, function string, args ...string) sc.Response {
+	t.Helper()
+	response, _ := l.invoke(c, function, args...)
+	if response.Status != shim.OK {
+		t.Fatalf("%s %v failed: %s", function, args, response.Message)
+	}
+	return response
+}
+
+// mustFail runs a transaction that has to fail before writing anything
+func (l *ledger) mustFail(t *testing.T, c caller, want string, function string, args ...string) {
+	t.Helper()
+	before := l.clone()
+	response, stub := l.invoke(c, function, args...)
+	if response.Status == shim.OK {
+		t.Fatalf("%s %v succeeded, expected it to fail with %q", function, args, want)
+	}
+	if !strings.Contains(response.Message, want) {
+		t.Fatalf("%s %v failed with %q, expected %q", function, args, response.Message, want)
+	}
+	if len(stub.writes) != 0 {
+		t.Fatalf("%s %v failed with %q after writing %+v", function, args, response.Message, stub.writes)
+	}
+	if !l.equal(before) {
+		t.Fatalf("%s %v failed with %q but changed the ledger", function, args, response.Message)
+	}
+}
+
+// mustInvokeAtomically runs a transaction that has to succeed, after checking
+// that it fails and leaves the ledger byte for byte as it was whichever of its
+// ledger or identity calls fails
+func (l *ledger) mustInvokeAtomically(t *testing.T, c caller, function string, args ...string) sc.Response {
+	t.Helper()
+
+	dryRun := l.clone()
+	stub := dryRun.transaction(c, 0, function, args...)
+	if response := new(SmartContract).Invoke(stub); response.Status != shim.OK {
+		t.Fatalf("%s %v failed: %s", function, args, response.Message)
+	}
+	if len(stub.writes) == 0 {
+		t.Fatalf("%s %v wrote nothing", function, args)
+	}
+
+	for failAt := 1; failAt <= stub.calls; failAt++ {
+		failing := l.clone()
+		failingStub := failing.transaction(c, failAt, function, args...)
+		response := new(SmartContract).Invoke(failingStub)
+		if response.Status == shim.OK {
+			t.Fatalf("%s %v succeeded although call %d of %d failed", function, args, failAt, stub.calls)
+		}
+		if len(failingStub.events) != 0 {
+			t.Fatalf("%s %v set an event although call %d of %d failed", function, args, failAt, stub.calls)
+		}
+		if !failing.equal(l) {
+			t.Fatalf("%s %v changed the ledger although call %d of %d failed", function, args, failAt, stub.calls)
+		}
+	}
+
+	return l.mustInvoke(t, c, function, args...)
+}
+
+func (l *ledger) license(t *testing.T, id string) License {
+	t.Helper()
+	licenseAsBytes, ok := l.state[id]
+	if !ok {
+		t.Fatalf("license %s does not exist", id)
+	}
+	license := License{}
+	if err := json.Unmarshal(licenseAsBytes, &license); err != nil {
+		t.Fatal(err)
+	}
+	return license
+}
+
+// assertStatus checks that a license has the given status and is listed in
+// the index of that status only
+func (l *ledger) assertStatus(t *testing.T, id string, status string) {
+	t.Helper()
+	if license := l.license(t, id); license.Status != status {
+		t.Fatalf("expected license %s to be %s, it is %s", id, status, license.Status)
+	}
+	for indexStatus, index := range statusIndex {
+		_, listed := l.state["\x00"+index+"\x00current\x00"+id+"\x00"]
+		if listed != (indexStatus == status) {
+			t.Fatalf("license %s is %s, but listed in %s is %v", id, status, index, listed)
+		}
+	}
+}
+
+var nidKey = strings.Repeat("6e", minSecretBytes)
+
+func salt(n int) string {
+	return strings.Repeat(strconv.Itoa(n%10), 2*minSecretBytes)
+}
+
+// newTestLedger returns a ledger with the NID key set
+func newTestLedger(t *testing.T) *ledger {
+	t.Helper()
+	l := newLedger()
+	l.mustInvoke(t, org1(t, "org1-admin").with(t, map[string]interface{}{NIDKeyTransientKey: nidKey}), "setNIDKey")
+	return l
+}
+
+// createLearner creates a learner license for a holder born in 1990
+func createLearner(t *testing.T, l *ledger, id string, nid string) {
+	t.Helper()
+	pii := PIIInput{Name: "Holder " + id, NID: nid, DOB: "1990-04-01", Salt: salt(len(l.state))}
+	approver := org1(t, "org1-approver").with(t, map[string]interface{}{PIITransientKey: pii, NIDKeyTransientKey: nidKey})
+	l.mustInvokeAtomically(t, approver, "createLearnerLicense", id)
+	l.assertStatus(t, id, StatusLearner)
+}
+
+var examiners = map[string]string{"test1": "org1-examcenter1", "test2": "org1-examcenter2", "test3": "org1-examcenter3"}
+
+// passTests records a pass at each of the given tests
+func passTests(t *testing.T, l *ledger, id string, tests ...string) {
+	t.Helper()
+	for _, test := range tests {
+		l.mustInvokeAtomically(t, org1(t, examiners[test]), "recordTestResult", id, test, "80")
+	}
+}
+
+// createActive creates a license and issues it
+func createActive(t *testing.T, l *ledger, id string, nid string) {
+	t.Helper()
+	createLearner(t, l, id, nid)
+	passTests(t, l, id, "test1", "test2", "test3")
+	l.mustInvoke(t, org1(t, "org1-approver"), "upgradeLearnerToActive", id, "approved")
+}
+
+func TestCreatePoliceReportIsAtomic(t *testing.T) {
+	l := newTestLedger(t)
+	createActive(t, l, "L1", "N1")
+	police := org2(t, "org2-police")
+
+	l.mustInvokeAtomically(t, police, "createPoliceReport", "R1", "L1", "High", "speeding", "6")
+	if license := l.license(t, "L1"); license.Point != InitialPoints-6 {
+		t.Fatalf("expected %d points, got %d", InitialPoints-6, license.Point)
+	}
+	if _, ok := l.state["\x00crime~key\x00L1\x00R1\x00"]; !ok {
+		t.Fatal("expected the report to be indexed under crime~key")
+	}
+
+	// every check comes before the first write
+	l.mustFail(t, police, ErrDuplicateReport, "createPoliceReport", "R1", "L1", "High", "speeding", "6")
+	l.mustFail(t, police, ErrInvalidLevel, "createPoliceReport", "R2", "L1", "Severe", "speeding", "6")
+	l.mustFail(t, police, ErrInvalidDeduction, "createPoliceReport", "R2", "L1", "High", "speeding", "16")
+	l.mustFail(t, police, ErrLicenseNotFound, "createPoliceReport", "R2", "L9", "High", "speeding", "6")
+	l.mustFail(t, org1(t, "org1-approver"), ErrUnauthorized, "createPoliceReport", "R2", "L1", "High", "speeding", "6")
+
+	createLearner(t, l, "L2", "N2")
+	l.mustFail(t, police, ErrLicenseNotReportable, "createPoliceReport", "R2", "L2", "High", "speeding", "6")
+}
+
+func TestUpgradeLearnerToActiveIsAtomic(t *testing.T) {
+	l := newTestLedger(t)
+	createLearner(t, l, "L1", "N1")
+	approver := org1(t, "org1-approver")
+
+	l.mustFail(t, approver, "only Waiting licenses", "upgradeLearnerToActive", "L1", "approved")
+	passTests(t, l, "L1", "test1", "test2", "test3")
+	l.assertStatus(t, "L1", StatusWaiting)
+
+	l.mustInvokeAtomically(t, approver, "upgradeLearnerToActive", "L1", "approved")
+	l.assertStatus(t, "L1", StatusActive)
+	if _, ok := l.params["L1"]; !ok {
+		t.Fatal("expected an Active license to be under licenseEndorsers")
+	}
+
+	l.mustFail(t, approver, "only Waiting licenses", "upgradeLearnerToActive", "L1", "approved")
+	l.mustFail(t, approver, "does not exist", "upgradeLearnerToActive", "L9", "approved")
+}
+
+func TestRecordTestIsAtomic(t *testing.T) {
+	l := newTestLedger(t)
+	createLearner(t, l, "L1", "N1")
+
+	l.mustFail(t, org1(t, "org1-examcenter2"), "Only user with role as org1-examcenter1", "recordTestResult", "L1", "test1", "80")
+	l.mustFail(t, org1(t, "org1-examcenter1"), "Unknown test type", "recordTestResult", "L1", "test9", "80")
+
+	l.mustInvokeAtomically(t, org1(t, "org1-examcenter1"), "recordTestResult", "L1", "test1", "20")
+	l.mustFail(t, org1(t, "org1-examcenter1"), "can not retake", "recordTestResult", "L1", "test1", "80")
+	l.now = l.now.Add(days(7))
+	passTests(t, l, "L1", "test1", "test2")
+	l.mustFail(t, org1(t, "org1-examcenter1"), "already passed", "recordTestResult", "L1", "test1", "80")
+
+	// the last test moves the learner to Waiting in the same transaction
+	passTests(t, l, "L1", "test3")
+	l.assertStatus(t, "L1", StatusWaiting)
+	l.mustFail(t, org1(t, "org1-examcenter3"), "test results can only be recorded for learners", "recordTestResult", "L1", "test3", "80")
+
+	createLearner(t, l, "L2", "N2")
+	for attempt := 0; attempt < defaultTestTypes["test3"].MaxAttempts; attempt++ {
+		l.mustInvoke(t, org1(t, "org1-examcenter3"), "recordTestResult", "L2", "test3", "10")
+		l.now = l.now.Add(days(defaultTestTypes["test3"].CooldownDays))
+	}
+	l.mustFail(t, org1(t, "org1-examcenter3"), "has used all", "recordTestResult", "L2", "test3", "80")
+
+	l.now = l.now.Add(days(defaultConfig.LearnerValidityDays))
+	l.mustFail(t, org1(t, "org1-examcenter1"), "has lapsed", "recordTestResult", "L2", "test1", "80")
+}
+
+func TestLicenseLifecycle(t *testing.T) {
+	l := newTestLedger(t)
+	approver := org1(t, "org1-approver")
+	createLearner(t, l, "L1", "N1")
+	passTests(t, l, "L1", "test1", "test2", "test3")
+	l.assertStatus(t, "L1", StatusWaiting)
+	l.mustInvokeAtomically(t, approver, "upgradeLearnerToActive", "L1", "approved")
+	l.assertStatus(t, "L1", StatusActive)
+
+	// a report taking every point moves the license to ToStall
+	l.mustInvokeAtomically(t, org2(t, "org2-police"), "createPoliceReport", "R1", "L1", "Critical", "drunk driving", strconv.Itoa(InitialPoints))
+	l.assertStatus(t, "L1", StatusToStall)
+
+	l.mustInvokeAtomically(t, approver, "revokeLicense", "L1", "no points left")
+	l.assertStatus(t, "L1", StatusStalled)
+	l.mustFail(t, approver, "can not be reinstated before", "reinstateLicense", "L1", "served")
+
+	l.now = l.now.Add(days(defaultConfig.MinSuspensionDays))
+	l.mustInvokeAtomically(t, approver, "reinstateLicense", "L1", "served")
+	l.assertStatus(t, "L1", StatusActive)
+	if license := l.license(t, "L1"); license.Point != InitialPoints {
+		t.Fatalf("expected %d points after reinstatement, got %d", InitialPoints, license.Point)
+	}
+
+	l.now = l.now.AddDate(defaultConfig.LicenseValidityYears, 0, 0)
+	l.mustInvokeAtomically(t, approver, "sweepExpiredLicenses", "10", "")
+	l.assertStatus(t, "L1", StatusExpired)
+
+	l.mustInvokeAtomically(t, approver, "renewLicense", "L1")
+	l.assertStatus(t, "L1", StatusActive)
+
+	l.mustInvokeAtomically(t, approver, "archiveLicense", "L1", "holder deceased")
+	l.assertStatus(t, "L1", StatusCancelled)
+	l.mustFail(t, approver, "Illegal status transition", "revokeLicense", "L1", "again")
+	l.mustFail(t, approver, "already archived", "archiveLicense", "L1", "again")
+
+	// the archived license released its NID
+	createLearner(t, l, "L2", "N1")
+}
+
+func TestLearnerPermitLapses(t *testing.T) {
+	l := newTestLedger(t)
+	approver := org1(t, "org1-approver")
+	createLearner(t, l, "L1", "N1")
+	createLearner(t, l, "L2", "N2")
+	createLearner(t, l, "L3", "N3")
+	passTests(t, l, "L3", "test1", "test2", "test3")
+
+	l.now = l.now.Add(days(defaultConfig.LearnerValidityDays))
+	l.mustInvokeAtomically(t, approver, "sweepExpiredLearners", "1", "")
+	l.assertStatus(t, "L1", StatusExpired)
+	l.assertStatus(t, "L2", StatusLearner)
+
+	response := l.mustInvoke(t, approver, "sweepExpiredLearners", "10", "")
+	result := SweepResult{}
+	if err := json.Unmarshal(response.Payload, &result); err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Expired) != 1 || result.Expired[0] != "L2" || result.Bookmark != "" {
+		t.Fatalf("expected the second sweep to expire L2 only, got %+v", result)
+	}
+	l.assertStatus(t, "L2", StatusExpired)
+	l.assertStatus(t, "L3", StatusWaiting)
+
+	// expired learner permits were never issued and can not be renewed
+	l.mustFail(t, approver, "was never issued", "renewLicense", "L1")
+	pii := PIIInput{Name: "Holder L4", NID: "N1", DOB: "1990-04-01", Salt: salt(4)}
+	l.mustFail(t, approver.with(t, map[string]interface{}{PIITransientKey: pii, NIDKeyTransientKey: nidKey}), "NID already exists", "createLearnerLicense", "L4")
+}
+
+func TestQueryLicenseByNID(t *testing.T) {
+	l := newTestLedger(t)
+	createActive(t, l, "L1", "N1")
+	police := org2(t, "org2-police")
+
+	lookup := func(nid string) caller {
+		return police.with(t, map[string]interface{}{PIITransientKey: PIIInput{NID: nid}, NIDKeyTransientKey: nidKey})
+	}
+	response := l.mustInvoke(t, lookup("N1"), "queryLicenseByNID")
+	license := License{}
+	if err := json.Unmarshal(response.Payload, &license); err != nil {
+		t.Fatal(err)
+	}
+	if license.ID != "L1" || hasPII(license) {
+		t.Fatalf("expected the public record of L1, got %+v", license)
+	}
+
+	// the NID stays out of the arguments and the error
+	l.mustFail(t, lookup("N1"), "no longer accepted as an argument", "queryLicenseByNID", "N1")
+	response, _ = l.invoke(lookup("N2"), "queryLicenseByNID")
+	if response.Status == shim.OK || strings.Contains(response.Message, "N2") {
+		t.Fatalf("expected a not-found error without the NID, got %d %q", response.Status, response.Message)
+	}
+}
+
+func TestSearchLicensesPages(t *testing.T) {
+	l := newTestLedger(t)
+	for i := 1; i <= 5; i++ {
+		createLearner(t, l, "L"+strconv.Itoa(i), "N"+strconv.Itoa(i))
+	}
+	passTests(t, l, "L2", "test1")
+	passTests(t, l, "L4", "test1", "test2", "test3")
+	passTests(t, l, "L5", "test1", "test2", "test3")
+	police := org2(t, "org2-police")
+
+	// learners with test1 passed, then waiting licenses, two at a time
+	found := []string{}
+	filter := LicenseFilter{Status: []string{StatusLearner, StatusWaiting}, Tests: map[string]bool{"test1": true}, PageSize: 2}
+	for pages := 0; ; pages++ {
+		if pages == 5 {
+			t.Fatalf("search did not end, found %v", found)
+		}
+		filterAsBytes, err := json.Marshal(filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		page := LicensePage{}
+		if err := json.Unmarshal(l.mustInvoke(t, police, "searchLicenses", string(filterAsBytes)).Payload, &page); err != nil {
+			t.Fatal(err)
+		}
+		if len(page.Records) > filter.PageSize {
+			t.Fatalf("expected at most %d licenses, got %d", filter.PageSize, len(page.Records))
+		}
+		for _, license := range page.Records {
+			found = append(found, license.ID)
+		}
+		if page.Bookmark == "" {
+			break
+		}
+		filter.Bookmark = page.Bookmark
+	}
+	if strings.Join(found, ",") != "L2,L4,L5" {
+		t.Fatalf("expected L2,L4,L5, got %v", found)
+	}
+
+	l.mustFail(t, police, "unknown field", "searchLicenses", `{"sort":"point"}`)
+	l.mustFail(t, police, "Invalid bookmark", "searchLicenses", `{"status":["Active"],"bookmark":"Learner:"}`)
+}
+
+func TestDismissedAppealSettlesRestoredPoints(t *testing.T) {
+	l := newTestLedger(t)
+	createActive(t, l, "L1", "N1")
+	police := org2(t, "org2-police")
+	approver := org1(t, "org1-approver")
+	adjudicator := org1(t, "org1-adjudicator")
+	period := days(defaultConfig.PointRestorePeriodDays)
+
+	l.mustInvoke(t, police, "createPoliceReport", "R1", "L1", "Critical", "speeding", "10")
+	l.now = l.now.Add(period / 2)
+	l.mustInvoke(t, police, "createPoliceReport", "R2", "L1", "Critical", "speeding", "4")
+	l.mustInvoke(t, approver, "fileAppeal", "R2", "wrong plate")
+
+	// two full periods have passed since R2, which still stood until now
+	l.now = l.now.Add(period*2 + period/2)
+	l.mustInvokeAtomically(t, adjudicator, "resolveAppeal", "R2", ReportDismissed, "plate misread")
+	want := InitialPoints - 10 - 4 + 2*defaultConfig.PointRestoreAmount + 4
+	if license := l.license(t, "L1"); license.Point != want {
+		t.Fatalf("expected %d points after the refund, got %d", want, license.Point)
+	}
+
+	// a refund never takes a license past InitialPoints
+	l.mustInvoke(t, police, "createPoliceReport", "R3", "L1", "Critical", "speeding", "4")
+	l.mustInvoke(t, approver, "fileAppeal", "R3", "wrong plate")
+	l.now = l.now.Add(period * 2)
+	l.mustInvoke(t, adjudicator, "resolveAppeal", "R3", ReportDismissed, "plate misread")
+	if license := l.license(t, "L1"); license.Point != InitialPoints {
+		t.Fatalf("expected the refund to stop at %d points, got %d", InitialPoints, license.Point)
+	}
+}
+
+func TestUpdateACLKeepsAdminFunctionsClosed(t *testing.T) {
+	l := newTestLedger(t)
+	admin := org1(t, "org1-admin")
+
+	for function := range adminFunctions {
+		rule, ok := defaultACL[function]
+		if !ok || contains(rule.Roles, AnyRole) {
+			t.Fatalf("admin function %s must have a default rule restricted to named roles, got %+v", function, rule)
+		}
+		rules := `{"` + function + `": {"roles": ["` + AnyRole + `"]}}`
+		l.mustFail(t, admin, function+" can not be opened to every role", "updateACL", rules)
+		rules = `{"queryLicense": {"roles": ["` + AnyRole + `"]}, "` + function + `": {"roles": ["org1-approver", "` + AnyRole + `"]}}`
+		l.mustFail(t, admin, function+" can not be opened to every role", "updateACL", rules)
+	}
+
+	// admin functions can still be handed to another named role
+	l.mustInvoke(t, admin, "updateACL", `{"queryACL": {"roles": ["org1-admin", "org1-approver"]}}`)
+	l.mustInvoke(t, org1(t, "org1-approver"), "queryACL")
+	l.mustFail(t, org1(t, "org1-holder"), "Access denied", "queryACL")
+}
+
+func TestLegacyResultsSkipRetakeRules(t *testing.T) {
+	l := newTestLedger(t)
+	createLearner(t, l, "L1", "N1")
+	examiner := org1(t, "org1-examcenter1")
+
+	// legacy fails can be retaken at once and as often as needed
+	for i := 0; i < defaultTestTypes["test1"].MaxAttempts+1; i++ {
+		l.mustInvokeAtomically(t, examiner, "inputTest1Result", "L1", TestFailed)
+	}
+	// and do not use up the attempts or start the cooldown of scored tests
+	l.mustInvoke(t, examiner, "recordTestResult", "L1", "test1", "10")
+	l.mustFail(t, examiner, "can not retake", "recordTestResult", "L1", "test1", "10")
+	l.mustInvoke(t, examiner, "inputTest1Result", "L1", TestPassed)
+
+	attempts := []TestAttempt{}
+	if err := json.Unmarshal(l.mustInvoke(t, org1(t, "org1-approver"), "queryTestAttempts", "L1", "test1").Payload, &attempts); err != nil {
+		t.Fatal(err)
+	}
+	legacy := 0
+	for _, attempt := range attempts {
+		if attempt.Legacy {
+			legacy++
+		}
+	}
+	if len(attempts) != defaultTestTypes["test1"].MaxAttempts+3 || legacy != len(attempts)-1 {
+		t.Fatalf("expected every attempt in the history and all but one of them legacy, got %+v", attempts)
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import "time"
+
+// The payload emitted by the licensus chaincode, described by
+// docs/events.schema.json
+
+// licensusEventName : name of the chaincode event carrying TransactionEvents
+const licensusEventName = "LicensusEvents"
+
+// Event types
+const (
+	EventLicenseCreated       = "LicenseCreated"
+	EventTestRecorded         = "TestRecorded"
+	EventMovedToWaiting       = "MovedToWaiting"
+	EventActivated            = "Activated"
+	EventViolationReported    = "ViolationReported"
+	EventFlaggedForStall      = "FlaggedForStall"
+	EventRevoked              = "Revoked"
+	EventExpired              = "Expired"
+	EventCancelled            = "Cancelled"
+	EventPurged               = "Purged"
+	EventAppealFiled          = "AppealFiled"
+	EventAppealResolved       = "AppealResolved"
+	EventPointsChanged        = "PointsChanged"
+	EventNIDCorrected         = "NIDCorrected"
+	EventDOBCorrected         = "DOBCorrected"
+	EventEndorsementAdded     = "EndorsementAdded"
+	EventEndorsementCancelled = "EndorsementCancelled"
+
+	// registry events concern no license
+	EventTestTypeRegistered     = "TestTypeRegistered"
+	EventLicenseClassRegistered = "LicenseClassRegistered"
+
+	// EventDeleted was emitted by deleteLicense until archiveLicense replaced
+	// it, and is still found in older blocks
+	EventDeleted = "Deleted"
+)
+
+// Event : one thing that happened to a license or a registry
+type Event struct {
+	Type       string `json:"type"`
+	LicenseID  string `json:"licenseId,omitempty"`
+	FromStatus string `json:"fromStatus,omitempty"`
+	ToStatus   string `json:"toStatus,omitempty"`
+	Class      string `json:"class,omitempty"`
+	TestType   string `json:"testType,omitempty"`
+	Score      *int   `json:"score,omitempty"`
+	Passed     *bool  `json:"passed,omitempty"`
+	ReportID   string `json:"reportId,omitempty"`
+	Level      string `json:"level,omitempty"`
+	Points     *int   `json:"points,omitempty"`
+	Reason     string `json:"reason,omitempty"`
+	Decision   string `json:"decision,omitempty"`
+}
+
+// TransactionEvents : the events of one transaction in the order they happened
+type TransactionEvents struct {
+	TxID      string    `json:"txId"`
+	Function  string    `json:"function"`
+	Timestamp time.Time `json:"timestamp"`
+	Events    []Event   `json:"events"`
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+	"github.com/hyperledger/fabric-gateway/pkg/identity"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// GatewayConfig : how to reach a peer through the Fabric Gateway
+type GatewayConfig struct {
+	PeerEndpoint  string
+	PeerHostname  string
+	TLSCertPath   string
+	MSPID         string
+	CertPath      string
+	KeyPath       string
+	ChannelName   string
+	ChaincodeName string
+}
+
+// GatewaySource delivers the chaincode events of a peer through the Fabric
+// Gateway, which needs a Fabric 2.4 or later peer with the gateway enabled
+type GatewaySource struct {
+	Config GatewayConfig
+}
+
+func (s GatewaySource) Events(ctx context.Context, startBlock uint64) (<-chan ChaincodeEvent, <-chan error) {
+	events := make(chan ChaincodeEvent)
+	errs := make(chan error, 1)
+
+	go func() {
+		defer close(events)
+
+		conn, err := s.dial()
+		if err != nil {
+			errs <- err
+			return
+		}
+		defer conn.Close()
+
+		gateway, err := s.connect(conn)
+		if err != nil {
+			errs <- err
+			return
+		}
+		defer gateway.Close()
+
+		network := gateway.GetNetwork(s.Config.ChannelName)
+		peerEvents, err := network.ChaincodeEvents(ctx, s.Config.ChaincodeName, client.WithStartBlock(startBlock))
+		if err != nil {
+			errs <- err
+			return
+		}
+
+		for event := range peerEvents {
+			select {
+			case events <- ChaincodeEvent{
+				BlockNumber:   event.BlockNumber,
+				TransactionID: event.TransactionID,
+				ChaincodeName: event.ChaincodeName,
+				EventName:     event.EventName,
+				Payload:       event.Payload,
+			}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return events, errs
+}
+
+func (s GatewaySource) dial() (*grpc.ClientConn, error) {
+	tlsPEM, err := os.ReadFile(s.Config.TLSCertPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read peer TLS certificate: %s", err.Error())
+	}
+	certPool := x509.NewCertPool()
+	if !certPool.AppendCertsFromPEM(tlsPEM) {
+		return nil, fmt.Errorf("no certificate found in %s", s.Config.TLSCertPath)
+	}
+
+	transport := credentials.NewClientTLSFromCert(certPool, s.Config.PeerHostname)
+	return grpc.Dial(s.Config.PeerEndpoint, grpc.WithTransportCredentials(transport))
+}
+
+func (s GatewaySource) connect(conn *grpc.ClientConn) (*client.Gateway, error) {
+	certPEM, err := os.ReadFile(s.Config.CertPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read client certificate: %s", err.Error())
+	}
+	cert, err := identity.CertificateFromPEM(certPEM)
+	if err != nil {
+		return nil, err
+	}
+	id, err := identity.NewX509Identity(s.Config.MSPID, cert)
+	if err != nil {
+		return nil, err
+	}
+
+	keyPEM, err := os.ReadFile(s.Config.KeyPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read client key: %s", err.Error())
+	}
+	key, err := identity.PrivateKeyFromPEM(keyPEM)
+	if err != nil {
+		return nil, err
+	}
+	sign, err := identity.NewPrivateKeySign(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return client.Connect(id,
+		client.WithSign(sign),
+		client.WithClientConnection(conn),
+		client.WithEvaluateTimeout(5*time.Second),
+	)
+}
@@ -0,0 +1,110 @@
+// The listener keeps an off-chain read model of the licensus chaincode. It
+// consumes the LicensusEvents chaincode events from a Source, projects the
+// licenses, reports and status history they describe into a local LevelDB
+// database and checkpoints by block, so after a restart it resumes from the
+// last block it processed instead of replaying the channel.
+//
+// The gateway source uses the Fabric Gateway service, which peers only offer
+// from Fabric 2.4 on; against older peers use the file source. It is a module
+// of its own (go.mod), separate from the chaincode.
+//
+//	listener -db ./readmodel -source gateway -peer localhost:7051 \
+//	  -peer-host peer0.org1.example.com -tls-cert ca.crt \
+//	  -msp Org1MSP -cert user.pem -key user_sk
+//	listener -db ./readmodel -source file -file events.jsonl
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+func main() {
+	dbPath := flag.String("db", "readmodel", "directory of the read model database")
+	sourceName := flag.String("source", "gateway", "event source: gateway or file")
+	filePath := flag.String("file", "events.jsonl", "events file, one JSON event per line, for -source file")
+	retry := flag.Duration("retry", 5*time.Second, "delay before reconnecting to the gateway after it fails")
+
+	gateway := GatewayConfig{}
+	flag.StringVar(&gateway.PeerEndpoint, "peer", "localhost:7051", "peer gateway endpoint")
+	flag.StringVar(&gateway.PeerHostname, "peer-host", "peer0.org1.example.com", "peer TLS host name")
+	flag.StringVar(&gateway.TLSCertPath, "tls-cert", "", "peer TLS CA certificate")
+	flag.StringVar(&gateway.MSPID, "msp", "Org1MSP", "MSP ID of the listener identity")
+	flag.StringVar(&gateway.CertPath, "cert", "", "certificate of the listener identity")
+	flag.StringVar(&gateway.KeyPath, "key", "", "private key of the listener identity")
+	flag.StringVar(&gateway.ChannelName, "channel", "mychannel", "channel name")
+	flag.StringVar(&gateway.ChaincodeName, "chaincode", "licensus", "chaincode name")
+	flag.Parse()
+
+	var source Source
+	switch *sourceName {
+	case "gateway":
+		source = GatewaySource{Config: gateway}
+	case "file":
+		source = FileSource{Path: *filePath}
+		*retry = 0
+	default:
+		log.Fatalf("unknown source %q", *sourceName)
+	}
+
+	store, err := OpenStore(*dbPath)
+	if err != nil {
+		log.Fatalf("failed to open read model: %s", err)
+	}
+	defer store.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	for {
+		err := run(ctx, source, store)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			log.Printf("event source failed: %s", err)
+		}
+		if *retry == 0 {
+			if err != nil {
+				store.Close()
+				os.Exit(1)
+			}
+			return
+		}
+		select {
+		case <-time.After(*retry):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// run projects events from the checkpoint until the source stops
+func run(ctx context.Context, source Source, store *Store) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// the checkpoint block may have been left part way, it is delivered again
+	// and the transactions already applied are skipped
+	checkpoint := store.Checkpoint()
+	log.Printf("resuming from block %d", checkpoint.BlockNumber)
+
+	events, errs := source.Events(ctx, checkpoint.BlockNumber)
+	for event := range events {
+		if err := store.Apply(event); err != nil {
+			return err
+		}
+	}
+
+	select {
+	case err := <-errs:
+		return err
+	default:
+		return nil
+	}
+}
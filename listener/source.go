@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// ChaincodeEvent : a chaincode event as delivered by a Source, with the block
+// and transaction it was committed in
+type ChaincodeEvent struct {
+	BlockNumber   uint64          `json:"blockNumber"`
+	TransactionID string          `json:"txId"`
+	ChaincodeName string          `json:"chaincodeName"`
+	EventName     string          `json:"eventName"`
+	Payload       json.RawMessage `json:"payload"`
+}
+
+// Source delivers the chaincode events committed from a block onwards, in
+// commit order. The channel is closed once the source has nothing more to
+// deliver or the context is done; a delivery failure is reported through the
+// error channel before the events channel is closed.
+type Source interface {
+	Events(ctx context.Context, startBlock uint64) (<-chan ChaincodeEvent, <-chan error)
+}
+
+// FileSource reads events from a file holding one ChaincodeEvent JSON object
+// per line, as written by a capture of the peer events or by hand for tests
+type FileSource struct {
+	Path string
+}
+
+func (s FileSource) Events(ctx context.Context, startBlock uint64) (<-chan ChaincodeEvent, <-chan error) {
+	events := make(chan ChaincodeEvent)
+	errs := make(chan error, 1)
+
+	go func() {
+		defer close(events)
+
+		file, err := os.Open(s.Path)
+		if err != nil {
+			errs <- err
+			return
+		}
+		defer file.Close()
+
+		scanner := bufio.NewScanner(file)
+		scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+		for line := 1; scanner.Scan(); line++ {
+			if len(scanner.Bytes()) == 0 {
+				continue
+			}
+			event := ChaincodeEvent{}
+			if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+				errs <- fmt.Errorf("%s:%d: %s", s.Path, line, err.Error())
+				return
+			}
+			if event.BlockNumber < startBlock {
+				continue
+			}
+			select {
+			case events <- event:
+			case <-ctx.Done():
+				return
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			errs <- err
+		}
+	}()
+
+	return events, errs
+}
+
+// ChannelSource delivers the events sent on a Go channel, so tests can feed the
+// projector directly. Events before the start block are dropped, as a peer
+// would not deliver them.
+type ChannelSource struct {
+	C <-chan ChaincodeEvent
+}
+
+func (s ChannelSource) Events(ctx context.Context, startBlock uint64) (<-chan ChaincodeEvent, <-chan error) {
+	events := make(chan ChaincodeEvent)
+	errs := make(chan error, 1)
+
+	go func() {
+		defer close(events)
+		for {
+			select {
+			case event, ok := <-s.C:
+				if !ok {
+					return
+				}
+				if event.BlockNumber < startBlock {
+					continue
+				}
+				select {
+				case events <- event:
+				case <-ctx.Done():
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return events, errs
+}
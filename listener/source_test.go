@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// collect drains a source and returns what it delivered
+func collect(t *testing.T, source Source, startBlock uint64) ([]ChaincodeEvent, error) {
+	t.Helper()
+	events, errs := source.Events(context.Background(), startBlock)
+	delivered := []ChaincodeEvent{}
+	for event := range events {
+		delivered = append(delivered, event)
+	}
+	select {
+	case err := <-errs:
+		return delivered, err
+	default:
+		return delivered, nil
+	}
+}
+
+func writeEvents(t *testing.T, lines ...string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "events.jsonl")
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestFileSourceStartsAtBlock(t *testing.T) {
+	path := writeEvents(t,
+		`{"blockNumber":1,"txId":"tx1","eventName":"LicensusEvents","payload":{}}`,
+		``,
+		`{"blockNumber":2,"txId":"tx2","eventName":"LicensusEvents","payload":{}}`,
+		`{"blockNumber":3,"txId":"tx3","eventName":"LicensusEvents","payload":{}}`,
+	)
+
+	events, err := collect(t, FileSource{Path: path}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 || events[0].TransactionID != "tx2" || events[1].TransactionID != "tx3" {
+		t.Fatalf("expected tx2 and tx3, got %+v", events)
+	}
+}
+
+func TestFileSourceReportsBadLine(t *testing.T) {
+	path := writeEvents(t,
+		`{"blockNumber":1,"txId":"tx1","eventName":"LicensusEvents","payload":{}}`,
+		`{"blockNumber":`,
+	)
+
+	events, err := collect(t, FileSource{Path: path}, 0)
+	if err == nil || !strings.Contains(err.Error(), ":2:") {
+		t.Fatalf("expected an error for line 2, got %v", err)
+	}
+	if len(events) != 1 {
+		t.Fatalf("expected the event before the bad line, got %+v", events)
+	}
+}
+
+func TestFileSourceReportsMissingFile(t *testing.T) {
+	if _, err := collect(t, FileSource{Path: filepath.Join(t.TempDir(), "missing")}, 0); err == nil {
+		t.Fatal("expected an error for a missing file")
+	}
+}
+
+func TestChannelSourceStartsAtBlock(t *testing.T) {
+	c := make(chan ChaincodeEvent, 3)
+	c <- ChaincodeEvent{BlockNumber: 4, TransactionID: "tx4"}
+	c <- ChaincodeEvent{BlockNumber: 5, TransactionID: "tx5"}
+	c <- ChaincodeEvent{BlockNumber: 6, TransactionID: "tx6"}
+	close(c)
+
+	events, err := collect(t, ChannelSource{C: c}, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 || events[0].TransactionID != "tx5" || events[1].TransactionID != "tx6" {
+		t.Fatalf("expected tx5 and tx6, got %+v", events)
+	}
+}
+
+func TestChannelSourceStopsWithContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	events, _ := ChannelSource{C: make(chan ChaincodeEvent)}.Events(ctx, 0)
+	cancel()
+	if _, ok := <-events; ok {
+		t.Fatal("expected the events channel to be closed")
+	}
+}
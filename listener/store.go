@@ -0,0 +1,343 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// Keys of the read model. History keys sort by block, then by the position
+// of the transaction in the block and of the event in the transaction, so a
+// prefix scan returns a license history in commit order.
+const (
+	checkpointKey = "checkpoint"
+	licensePrefix = "license/"
+	reportPrefix  = "report/"
+	historyPrefix = "history/"
+)
+
+// Checkpoint : the last block the projection has applied transactions from,
+// and the transactions of that block already applied. A block is replayed
+// from the start after a restart; transactions listed here are skipped.
+type Checkpoint struct {
+	BlockNumber  uint64   `json:"blockNumber"`
+	Transactions []string `json:"transactions"`
+	Started      bool     `json:"started"`
+}
+
+func (c Checkpoint) applied(event ChaincodeEvent) bool {
+	if !c.Started || event.BlockNumber > c.BlockNumber {
+		return false
+	}
+	if event.BlockNumber < c.BlockNumber {
+		return true
+	}
+	for _, txID := range c.Transactions {
+		if txID == event.TransactionID {
+			return true
+		}
+	}
+	return false
+}
+
+// LicenseView : what the events tell about a license. LastReportedPoints is
+// the balance left by the latest violation report, Points the balance after
+// the latest violation or PointsChanged.
+type LicenseView struct {
+	ID                 string                     `json:"id"`
+	Status             string                     `json:"status"`
+	Class              string                     `json:"class"`
+	Classes            []string                   `json:"classes"`
+	Endorsements       map[string]string          `json:"endorsements"`
+	Tests              map[string]map[string]bool `json:"tests"`
+	LastReportedPoints *int                       `json:"lastReportedPoints,omitempty"`
+	Points             *int                       `json:"points,omitempty"`
+	Reports            []string                   `json:"reports"`
+	Deleted            bool                       `json:"deleted"`
+	Purged             bool                       `json:"purged"`
+	CreatedAt          time.Time                  `json:"createdAt"`
+	UpdatedAt          time.Time                  `json:"updatedAt"`
+	LastTxID           string                     `json:"lastTxId"`
+}
+
+// ReportView : a violation report as announced by ViolationReported, with the
+// status its appeal events moved it to
+type ReportView struct {
+	ID            string    `json:"id"`
+	LicenseID     string    `json:"licenseId"`
+	Level         string    `json:"level"`
+	Status        string    `json:"status"`
+	PointsLeft    int       `json:"pointsLeft"`
+	ReportedAt    time.Time `json:"reportedAt"`
+	BlockNumber   uint64    `json:"blockNumber"`
+	TransactionID string    `json:"txId"`
+}
+
+// HistoryEntry : one event in the history of a license
+type HistoryEntry struct {
+	Event
+	BlockNumber   uint64    `json:"blockNumber"`
+	TransactionID string    `json:"txId"`
+	Timestamp     time.Time `json:"timestamp"`
+}
+
+// Store projects licensus events into a local LevelDB database
+type Store struct {
+	db         *leveldb.DB
+	checkpoint Checkpoint
+}
+
+// OpenStore opens or creates the read model at path
+func OpenStore(path string) (*Store, error) {
+	db, err := leveldb.OpenFile(path, nil)
+	if err != nil {
+		return nil, err
+	}
+	store := &Store{db: db}
+	if _, err := store.get(checkpointKey, &store.checkpoint); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return store, nil
+}
+
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Checkpoint returns the position the projection has reached
+func (s *Store) Checkpoint() Checkpoint {
+	return s.checkpoint
+}
+
+// get decodes the value at key into v and reports whether it was found
+func (s *Store) get(key string, v interface{}) (bool, error) {
+	value, err := s.db.Get([]byte(key), nil)
+	if err == leveldb.ErrNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, json.Unmarshal(value, v)
+}
+
+func putJSON(batch *leveldb.Batch, key string, v interface{}) error {
+	value, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	batch.Put([]byte(key), value)
+	return nil
+}
+
+// Apply projects the events of one transaction and moves the checkpoint past
+// it in a single write, so a crash never leaves a transaction half applied.
+// Transactions already applied are skipped.
+func (s *Store) Apply(event ChaincodeEvent) error {
+	if s.checkpoint.applied(event) {
+		return nil
+	}
+
+	position := 0
+	if s.checkpoint.Started && s.checkpoint.BlockNumber == event.BlockNumber {
+		position = len(s.checkpoint.Transactions)
+	}
+
+	batch := new(leveldb.Batch)
+	if event.EventName == licensusEventName {
+		if err := s.project(batch, event, position); err != nil {
+			return fmt.Errorf("block %d tx %s: %s", event.BlockNumber, event.TransactionID, err.Error())
+		}
+	}
+
+	checkpoint := Checkpoint{BlockNumber: event.BlockNumber, Started: true}
+	if s.checkpoint.Started && s.checkpoint.BlockNumber == event.BlockNumber {
+		checkpoint.Transactions = append(checkpoint.Transactions, s.checkpoint.Transactions...)
+	}
+	checkpoint.Transactions = append(checkpoint.Transactions, event.TransactionID)
+	if err := putJSON(batch, checkpointKey, checkpoint); err != nil {
+		return err
+	}
+
+	if err := s.db.Write(batch, &opt.WriteOptions{Sync: true}); err != nil {
+		return err
+	}
+	s.checkpoint = checkpoint
+	return nil
+}
+
+func (s *Store) project(batch *leveldb.Batch, event ChaincodeEvent, position int) error {
+	tx := TransactionEvents{}
+	if err := json.Unmarshal(event.Payload, &tx); err != nil {
+		return err
+	}
+
+	// licenses touched earlier in the same transaction are not in the
+	// database yet
+	licenses := map[string]*LicenseView{}
+	newView := func(id string) *LicenseView {
+		return &LicenseView{ID: id, Classes: []string{}, Endorsements: map[string]string{}, Tests: map[string]map[string]bool{}, Reports: []string{}}
+	}
+	license := func(id string) (*LicenseView, error) {
+		if view, ok := licenses[id]; ok {
+			return view, nil
+		}
+		view := newView(id)
+		if _, err := s.get(licensePrefix+id, view); err != nil {
+			return nil, err
+		}
+		licenses[id] = view
+		return view, nil
+	}
+
+	for i, e := range tx.Events {
+		// the registries are not projected
+		if e.LicenseID == "" {
+			continue
+		}
+		view, err := license(e.LicenseID)
+		if err != nil {
+			return err
+		}
+		if e.Type == EventLicenseCreated {
+			// an ID can be reused once its license is deleted
+			*view = *newView(e.LicenseID)
+		}
+		view.UpdatedAt = tx.Timestamp
+		view.LastTxID = tx.TxID
+
+		switch e.Type {
+		case EventLicenseCreated:
+			view.Status, view.Class, view.CreatedAt = e.ToStatus, e.Class, tx.Timestamp
+		case EventTestRecorded:
+			if view.Tests[e.Class] == nil {
+				view.Tests[e.Class] = map[string]bool{}
+			}
+			view.Tests[e.Class][e.TestType] = e.Passed != nil && *e.Passed
+		case EventViolationReported:
+			view.LastReportedPoints, view.Points = e.Points, e.Points
+			view.Reports = append(view.Reports, e.ReportID)
+			report := ReportView{ID: e.ReportID, LicenseID: e.LicenseID, Level: e.Level, Status: "Filed", ReportedAt: tx.Timestamp, BlockNumber: event.BlockNumber, TransactionID: tx.TxID}
+			if e.Points != nil {
+				report.PointsLeft = *e.Points
+			}
+			if err := putJSON(batch, reportPrefix+e.ReportID, report); err != nil {
+				return err
+			}
+		case EventAppealFiled, EventAppealResolved:
+			// reports filed before the projection started are only known
+			// from their appeal
+			report := ReportView{ID: e.ReportID, LicenseID: e.LicenseID}
+			if _, err := s.get(reportPrefix+e.ReportID, &report); err != nil {
+				return err
+			}
+			report.Status = "UnderAppeal"
+			if e.Type == EventAppealResolved {
+				report.Status = e.Decision
+			}
+			if err := putJSON(batch, reportPrefix+e.ReportID, report); err != nil {
+				return err
+			}
+		case EventPointsChanged:
+			view.Points = e.Points
+		case EventEndorsementAdded:
+			view.Endorsements[e.Class] = e.ToStatus
+		case EventEndorsementCancelled:
+			delete(view.Endorsements, e.Class)
+		case EventNIDCorrected, EventDOBCorrected:
+			// personal data is not projected, the history records the change
+		case EventDeleted:
+			view.Status, view.Deleted = "", true
+		case EventPurged:
+			view.Purged = true
+		default:
+			// status changes of an endorsement carry its class, those of the
+			// license itself do not
+			if e.Class == "" {
+				view.Status = e.ToStatus
+			} else if e.Type == EventActivated {
+				delete(view.Endorsements, e.Class)
+				view.Classes = append(view.Classes, e.Class)
+			} else {
+				view.Endorsements[e.Class] = e.ToStatus
+			}
+			if e.Class == "" && e.Type == EventActivated && len(view.Classes) == 0 {
+				view.Classes = []string{view.Class}
+			}
+		}
+
+		entry := HistoryEntry{Event: e, BlockNumber: event.BlockNumber, TransactionID: tx.TxID, Timestamp: tx.Timestamp}
+		key := fmt.Sprintf("%s%s/%020d/%06d/%04d", historyPrefix, e.LicenseID, event.BlockNumber, position, i)
+		if err := putJSON(batch, key, entry); err != nil {
+			return err
+		}
+	}
+
+	for id, view := range licenses {
+		if err := putJSON(batch, licensePrefix+id, view); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// License returns the projected license, or nil if no event mentioned it
+func (s *Store) License(id string) (*LicenseView, error) {
+	view := &LicenseView{}
+	found, err := s.get(licensePrefix+id, view)
+	if err != nil || !found {
+		return nil, err
+	}
+	return view, nil
+}
+
+// Licenses returns the projected licenses in a status, every license that is
+// not deleted when status is empty
+func (s *Store) Licenses(status string) ([]LicenseView, error) {
+	iter := s.db.NewIterator(util.BytesPrefix([]byte(licensePrefix)), nil)
+	defer iter.Release()
+
+	views := []LicenseView{}
+	for iter.Next() {
+		view := LicenseView{}
+		if err := json.Unmarshal(iter.Value(), &view); err != nil {
+			return nil, err
+		}
+		if view.Deleted || (status != "" && view.Status != status) {
+			continue
+		}
+		views = append(views, view)
+	}
+	return views, iter.Error()
+}
+
+// History returns the events of a license in commit order
+func (s *Store) History(id string) ([]HistoryEntry, error) {
+	iter := s.db.NewIterator(util.BytesPrefix([]byte(historyPrefix+id+"/")), nil)
+	defer iter.Release()
+
+	entries := []HistoryEntry{}
+	for iter.Next() {
+		entry := HistoryEntry{}
+		if err := json.Unmarshal(iter.Value(), &entry); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, iter.Error()
+}
+
+// Report returns a projected report, or nil if it was never announced
+func (s *Store) Report(id string) (*ReportView, error) {
+	view := &ReportView{}
+	found, err := s.get(reportPrefix+id, view)
+	if err != nil || !found {
+		return nil, err
+	}
+	return view, nil
+}
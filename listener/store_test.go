@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// txEvent builds the chaincode event of a transaction emitting the given events
+func txEvent(t *testing.T, block uint64, txID string, function string, events ...Event) ChaincodeEvent {
+	t.Helper()
+	payload, err := json.Marshal(TransactionEvents{TxID: txID, Function: function, Timestamp: time.Date(2024, 5, 1, 0, 0, int(block), 0, time.UTC), Events: events})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ChaincodeEvent{BlockNumber: block, TransactionID: txID, ChaincodeName: "licensus", EventName: licensusEventName, Payload: payload}
+}
+
+func openTestStore(t *testing.T, path string) *Store {
+	t.Helper()
+	store, err := OpenStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func intPtr(i int) *int {
+	return &i
+}
+
+func history(t *testing.T, store *Store, id string) []string {
+	t.Helper()
+	entries, err := store.History(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	txs := []string{}
+	for _, entry := range entries {
+		txs = append(txs, entry.TransactionID+":"+entry.Type)
+	}
+	return txs
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestApplyIsIdempotent(t *testing.T) {
+	store := openTestStore(t, t.TempDir())
+	defer store.Close()
+
+	created := txEvent(t, 1, "tx1", "createLearnerLicense", Event{Type: EventLicenseCreated, LicenseID: "L1", ToStatus: "Learner", Class: "light"})
+	for i := 0; i < 2; i++ {
+		if err := store.Apply(created); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if got := history(t, store, "L1"); !equal(got, []string{"tx1:LicenseCreated"}) {
+		t.Fatalf("expected one history entry, got %v", got)
+	}
+	checkpoint := store.Checkpoint()
+	if checkpoint.BlockNumber != 1 || !equal(checkpoint.Transactions, []string{"tx1"}) {
+		t.Fatalf("unexpected checkpoint %+v", checkpoint)
+	}
+}
+
+func TestCheckpointSurvivesRestart(t *testing.T) {
+	path := t.TempDir()
+	store := openTestStore(t, path)
+	if err := store.Apply(txEvent(t, 1, "tx1", "createLearnerLicense", Event{Type: EventLicenseCreated, LicenseID: "L1", ToStatus: "Learner", Class: "light"})); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Apply(txEvent(t, 2, "tx2", "recordTestResult", Event{Type: EventMovedToWaiting, LicenseID: "L1", FromStatus: "Learner", ToStatus: "Waiting"})); err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+
+	store = openTestStore(t, path)
+	defer store.Close()
+	checkpoint := store.Checkpoint()
+	if !checkpoint.Started || checkpoint.BlockNumber != 2 || !equal(checkpoint.Transactions, []string{"tx2"}) {
+		t.Fatalf("unexpected checkpoint after restart %+v", checkpoint)
+	}
+
+	// both transactions are delivered again, neither is applied twice
+	for _, event := range []ChaincodeEvent{
+		txEvent(t, 1, "tx1", "createLearnerLicense", Event{Type: EventLicenseCreated, LicenseID: "L1", ToStatus: "Learner", Class: "light"}),
+		txEvent(t, 2, "tx2", "recordTestResult", Event{Type: EventMovedToWaiting, LicenseID: "L1", FromStatus: "Learner", ToStatus: "Waiting"}),
+	} {
+		if err := store.Apply(event); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := history(t, store, "L1"); !equal(got, []string{"tx1:LicenseCreated", "tx2:MovedToWaiting"}) {
+		t.Fatalf("unexpected history %v", got)
+	}
+	view, err := store.License("L1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if view.Status != "Waiting" {
+		t.Fatalf("expected Waiting, got %s", view.Status)
+	}
+}
+
+// a block left part way is delivered again from the start: the transactions
+// already applied are skipped and the rest of the block is applied once
+func TestRunReplaysCheckpointBlock(t *testing.T) {
+	path := t.TempDir()
+	tx1 := txEvent(t, 1, "tx1", "createLearnerLicense", Event{Type: EventLicenseCreated, LicenseID: "L1", ToStatus: "Learner", Class: "light"})
+	tx2a := txEvent(t, 2, "tx2a", "recordTestResult", Event{Type: EventMovedToWaiting, LicenseID: "L1", FromStatus: "Learner", ToStatus: "Waiting"})
+	tx2b := txEvent(t, 2, "tx2b", "upgradeLearnerToActive", Event{Type: EventActivated, LicenseID: "L1", FromStatus: "Waiting", ToStatus: "Active"})
+	tx3 := txEvent(t, 3, "tx3", "createPoliceReport", Event{Type: EventViolationReported, LicenseID: "L1", ReportID: "R1", Level: "High", Points: intPtr(6)})
+
+	store := openTestStore(t, path)
+	for _, event := range []ChaincodeEvent{tx1, tx2a} {
+		if err := store.Apply(event); err != nil {
+			t.Fatal(err)
+		}
+	}
+	store.Close()
+
+	store = openTestStore(t, path)
+	defer store.Close()
+	c := make(chan ChaincodeEvent, 4)
+	for _, event := range []ChaincodeEvent{tx1, tx2a, tx2b, tx3} {
+		c <- event
+	}
+	close(c)
+	if err := run(context.Background(), ChannelSource{C: c}, store); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"tx1:LicenseCreated", "tx2a:MovedToWaiting", "tx2b:Activated", "tx3:ViolationReported"}
+	if got := history(t, store, "L1"); !equal(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+	view, err := store.License("L1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if view.Status != "Active" || !equal(view.Reports, []string{"R1"}) || view.Points == nil || *view.Points != 6 {
+		t.Fatalf("unexpected view %+v", view)
+	}
+	if checkpoint := store.Checkpoint(); checkpoint.BlockNumber != 3 || !equal(checkpoint.Transactions, []string{"tx3"}) {
+		t.Fatalf("unexpected checkpoint %+v", checkpoint)
+	}
+}
+
+func TestRunReplaysFileFromCheckpoint(t *testing.T) {
+	path := writeEvents(t, mustJSON(t, txEvent(t, 1, "tx1", "createLearnerLicense", Event{Type: EventLicenseCreated, LicenseID: "L1", ToStatus: "Learner", Class: "light"})),
+		mustJSON(t, txEvent(t, 2, "tx2", "archiveLicense", Event{Type: EventCancelled, LicenseID: "L1", FromStatus: "Learner", ToStatus: "Cancelled"})),
+	)
+	store := openTestStore(t, filepath.Join(t.TempDir(), "db"))
+	defer store.Close()
+
+	for i := 0; i < 2; i++ {
+		if err := run(context.Background(), FileSource{Path: path}, store); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := history(t, store, "L1"); !equal(got, []string{"tx1:LicenseCreated", "tx2:Cancelled"}) {
+		t.Fatalf("unexpected history %v", got)
+	}
+}
+
+func TestProjectsAppealsPointsAndEndorsements(t *testing.T) {
+	store := openTestStore(t, t.TempDir())
+	defer store.Close()
+
+	events := []ChaincodeEvent{
+		txEvent(t, 1, "tx1", "createPoliceReport", Event{Type: EventViolationReported, LicenseID: "L1", ReportID: "R1", Level: "High", Points: intPtr(0)}),
+		txEvent(t, 2, "tx2", "fileAppeal", Event{Type: EventAppealFiled, LicenseID: "L1", ReportID: "R1"}),
+		txEvent(t, 3, "tx3", "resolveAppeal",
+			Event{Type: EventAppealResolved, LicenseID: "L1", ReportID: "R1", Decision: "Dismissed"},
+			Event{Type: EventPointsChanged, LicenseID: "L1", Points: intPtr(4), Reason: "Refunded"},
+		),
+		txEvent(t, 4, "tx4", "addEndorsement", Event{Type: EventEndorsementAdded, LicenseID: "L1", Class: "heavy", ToStatus: "Learner"}),
+		txEvent(t, 5, "tx5", "cancelEndorsement", Event{Type: EventEndorsementCancelled, LicenseID: "L1", Class: "heavy", FromStatus: "Learner"}),
+		txEvent(t, 6, "tx6", "registerTestType", Event{Type: EventTestTypeRegistered, TestType: "eyesight"}),
+	}
+	for _, event := range events {
+		if err := store.Apply(event); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	report, err := store.Report("R1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Status != "Dismissed" {
+		t.Fatalf("expected a dismissed report, got %+v", report)
+	}
+	view, err := store.License("L1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if view.Points == nil || *view.Points != 4 || *view.LastReportedPoints != 0 {
+		t.Fatalf("expected 4 points after the refund, got %+v", view)
+	}
+	if len(view.Endorsements) != 0 {
+		t.Fatalf("expected the endorsement to be cancelled, got %v", view.Endorsements)
+	}
+	if license, err := store.License(""); err != nil || license != nil {
+		t.Fatalf("registry events must not project a license, got %+v", license)
+	}
+}
+
+func mustJSON(t *testing.T, v interface{}) string {
+	t.Helper()
+	value, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(value)
+}
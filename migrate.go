@@ -0,0 +1,315 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	sc "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+// MigrationProgress : what one migrateLedger call did. Bookmark is passed to
+// the next call until it comes back empty.
+type MigrationProgress struct {
+	SchemaVersion       int    `json:"schemaVersion"`
+	LicensesUpgraded    int    `json:"licensesUpgraded"`
+	ReportsUpgraded     int    `json:"reportsUpgraded"`
+	PIIMoved            int    `json:"piiMoved"`
+	StatusesDerived     int    `json:"statusesDerived"`
+	IndexesRebuilt      int    `json:"indexesRebuilt"`
+	PoliciesSet         int    `json:"policiesSet"`
+	Skipped             int    `json:"skipped"`
+	FetchedRecordsCount int32  `json:"fetchedRecordsCount"`
+	Bookmark            string `json:"bookmark"`
+}
+
+// putMissingIndex writes an index entry unless it is already there and
+// reports whether it had to be written
+func putMissingIndex(APIstub shim.ChaincodeStubInterface, objectType string, attributes []string) (bool, error) {
+	indexKey, err := APIstub.CreateCompositeKey(objectType, attributes)
+	if err != nil {
+		return false, err
+	}
+	existing, err := APIstub.GetState(indexKey)
+	if err != nil {
+		return false, err
+	}
+	if existing != nil {
+		return false, nil
+	}
+	return true, APIstub.PutState(indexKey, []byte{0x00})
+}
+
+// legacyStatus returns the status a license written before statuses were
+// tracked should have. Such ledgers only listed a learner that passed every
+// test in waiting~key and an active license out of points in tostall~key,
+// leaving its status as it was.
+func legacyStatus(license License) string {
+	if license.Status == StatusLearner && allTestsPassed(license.RequiredTests, license.Tests) {
+		return StatusWaiting
+	}
+	if license.Status == StatusActive && license.Point <= 0 {
+		return StatusToStall
+	}
+	return license.Status
+}
+
+// piiSecrets : what migrateLedger moves personal data out of public state
+// with: the NID key and a random seed the salt of each license is derived from
+type piiSecrets struct {
+	nidKey   []byte
+	saltSeed []byte
+}
+
+// salt derives the salt of a license from the seed
+func (secrets piiSecrets) salt(id string) string {
+	mac := hmac.New(sha256.New, secrets.saltSeed)
+	mac.Write([]byte(id))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// migrationSecrets reads the NID key (NIDKeyTransientKey) and the salt seed
+// (the salt of PIITransientKey) from the transient map. They are only needed
+// while licenses hold personal data in public state, so nil is returned when
+// neither is passed.
+func migrationSecrets(APIstub shim.ChaincodeStubInterface) (*piiSecrets, error) {
+	transient, err := APIstub.GetTransient()
+	if err != nil {
+		return nil, err
+	}
+	_, hasKey := transient[NIDKeyTransientKey]
+	_, hasSalt := transient[PIITransientKey]
+	if !hasKey && !hasSalt {
+		return nil, nil
+	}
+
+	key, err := transientNIDKey(APIstub)
+	if err != nil {
+		return nil, err
+	}
+	input, err := transientPII(APIstub, "salt")
+	if err != nil {
+		return nil, err
+	}
+	seed, err := decodeSecret("salt", input.Salt)
+	if err != nil {
+		return nil, err
+	}
+	return &piiSecrets{nidKey: key, saltSeed: seed}, nil
+}
+
+// migrateLicense rewrites a license at the current schema version, which moves
+// personal data still held in public state to PIICollection, moves it to the
+// status its legacy test results and points imply, and puts it back in the
+// index of its status only, under licenseEndorsers if its status is one of
+// endorsedStatuses
+func migrateLicense(APIstub shim.ChaincodeStubInterface, id string, secrets *piiSecrets, progress *MigrationProgress) error {
+	license, err := getLicense(APIstub, id)
+	if err != nil {
+		return err
+	}
+
+	// the nid~key entry of a license holding its NID in public state is keyed
+	// by the plain NID, a bare marker or the license ID; setPII moves it to the
+	// keyed hash. An NID already pointing at another license is left to
+	// correctNID.
+	changed := false
+	if hasPII(license) && license.PIIHash == "" {
+		if secrets == nil {
+			return fmt.Errorf("license %s holds personal data in public state, pass the NID key under %q and a salt under %q in the transient map", license.ID, NIDKeyTransientKey, PIITransientKey)
+		}
+		pii := HolderPII{LicenseID: license.ID, Name: license.Name, NID: license.NID, DOB: license.DOB, Salt: secrets.salt(license.ID)}
+		if err := setPII(APIstub, &license, pii, secrets.nidKey); err != nil {
+			return err
+		}
+		progress.PIIMoved++
+		changed = true
+	}
+	if status := legacyStatus(license); status != license.Status {
+		if err := setLicenseStatus(APIstub, &license, status); err != nil {
+			return err
+		}
+		progress.StatusesDerived++
+		changed = true
+	}
+	if changed || license.SchemaVersion < SchemaVersion {
+		if _, err := putLicense(APIstub, license); err != nil {
+			return err
+		}
+		if license.SchemaVersion < SchemaVersion {
+			progress.LicensesUpgraded++
+		}
+	}
+
+	removed, err := delStaleStatusIndexes(APIstub, license)
+	if err != nil {
+		return err
+	}
+	progress.IndexesRebuilt += removed
+	rebuilt, err := putMissingIndex(APIstub, statusIndex[license.Status], []string{"current", license.ID})
+	if err != nil {
+		return err
+	}
+	if rebuilt {
+		progress.IndexesRebuilt++
+	}
+
+	// archived licenses have released their NID
+	if license.NIDHash != "" && license.Status != StatusCancelled {
+		indexKey, err := nidIndexKey(APIstub, license.NIDHash)
+		if err != nil {
+			return err
+		}
+		owner, err := APIstub.GetState(indexKey)
+		if err != nil {
+			return err
+		}
+		if owner == nil || bytes.Equal(owner, legacyNIDMarker) {
+			if err := APIstub.PutState(indexKey, []byte(license.ID)); err != nil {
+				return err
+			}
+			progress.IndexesRebuilt++
+		}
+	}
+
+	set, err := requireLicenseEndorsers(APIstub, license)
+	if err != nil {
+		return err
+	}
+	progress.PoliciesSet += set
+
+	return nil
+}
+
+// migrateReport rewrites a report at the current schema version, restores its
+// crime~key entry and puts both under reportEndorsers
+func migrateReport(APIstub shim.ChaincodeStubInterface, id string, progress *MigrationProgress) error {
+	report, err := getReport(APIstub, id)
+	if err != nil {
+		return err
+	}
+
+	if report.SchemaVersion < SchemaVersion {
+		if _, err := putReport(APIstub, report); err != nil {
+			return err
+		}
+		progress.ReportsUpgraded++
+	}
+
+	rebuilt, err := putMissingIndex(APIstub, "crime~key", []string{report.Holder, report.ID})
+	if err != nil {
+		return err
+	}
+	if rebuilt {
+		progress.IndexesRebuilt++
+	}
+
+	set, err := requireReportEndorsers(APIstub, report)
+	if err != nil {
+		return err
+	}
+	progress.PoliciesSet += set
+
+	return nil
+}
+
+// migrateACL puts an ACL document stored before it was kept under aclEndorsers
+// under them
+func migrateACL(APIstub shim.ChaincodeStubInterface, progress *MigrationProgress) error {
+	key, err := aclKey(APIstub)
+	if err != nil {
+		return err
+	}
+	aclAsBytes, err := APIstub.GetState(key)
+	if err != nil || aclAsBytes == nil {
+		return err
+	}
+	set, err := requireEndorsers(APIstub, key, aclEndorsers)
+	if set {
+		progress.PoliciesSet++
+	}
+	return err
+}
+
+// migrateLedger pages through the licenses and reports of the world state,
+// upgrading each to the current schema version and rebuilding the composite
+// key indexes they should be listed in. args: page size, bookmark.
+// Fabric only paginates queries in read-only transactions, so the page is cut
+// here and the bookmark is the key the next page starts at. Index entries,
+// configuration and registries live under composite keys, which the range
+// query does not return; they are read through their own decoders and left as
+// they are, except that the first page puts the ACL document under aclEndorsers.
+// Licenses holding personal data in public state need the NID key and a salt
+// seed of fresh random bytes in the transient map, see migrationSecrets; the
+// NID key has to be set with setNIDKey first.
+func (s *SmartContract) migrateLedger(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+	pageSize, bookmark, err := parsePageArgs(args[0], args[1])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	resultsIterator, err := APIstub.GetStateByRange(bookmark, "")
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	secrets, err := migrationSecrets(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	progress := MigrationProgress{SchemaVersion: SchemaVersion}
+	if bookmark == "" {
+		if err := migrateACL(APIstub, &progress); err != nil {
+			return shim.Error("Failed to migrate the ACL: " + err.Error())
+		}
+	}
+	for resultsIterator.HasNext() {
+		responseRange, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if progress.FetchedRecordsCount == pageSize {
+			progress.Bookmark = responseRange.Key
+			break
+		}
+		progress.FetchedRecordsCount++
+
+		// licenses carry a license status, reports a holder
+		record := struct {
+			Status string `json:"status"`
+			Holder string `json:"holder"`
+		}{}
+		if err := json.Unmarshal(responseRange.Value, &record); err != nil {
+			progress.Skipped++
+			continue
+		}
+
+		if _, ok := statusIndex[record.Status]; ok && record.Holder == "" {
+			err = migrateLicense(APIstub, responseRange.Key, secrets, &progress)
+		} else if record.Holder != "" {
+			err = migrateReport(APIstub, responseRange.Key, &progress)
+		} else {
+			progress.Skipped++
+			continue
+		}
+		if err != nil {
+			return shim.Error("Failed to migrate " + responseRange.Key + ": " + err.Error())
+		}
+	}
+
+	progressAsBytes, err := json.Marshal(progress)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(progressAsBytes)
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// SchemaVersion : version of the records written by this chaincode. Records
+// written before versions were recorded decode as version 1, which stored
+// points, deductions and test results as strings. Version 2 records have no
+// docType, and version 3 licenses hold the holder's name, NID and date of birth
+// in public state.
+const SchemaVersion = 4
+
+// Document types, stored as docType so CouchDB queries and indexes can tell
+// the records of the world state apart
+const (
+	DocTypeLicense     = "license"
+	DocTypeReport      = "report"
+	DocTypeTestAttempt = "testAttempt"
+)
+
+// legacySchemaVersion : version given to records that carry none
+const legacySchemaVersion = 1
+
+// publicPIISchemaVersion : version of the licenses that hold personal data in
+// public state
+const publicPIISchemaVersion = 3
+
+// TestResults : test results keyed by test type ID, true once passed.
+// Version 1 records stored "Yes"/"No" strings, which are still accepted.
+type TestResults map[string]bool
+
+func (r *TestResults) UnmarshalJSON(data []byte) error {
+	raw := map[string]interface{}{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	results := TestResults{}
+	for id, value := range raw {
+		passed, err := legacyTestResult(value)
+		if err != nil {
+			return fmt.Errorf("test %s: %s", id, err.Error())
+		}
+		results[id] = passed
+	}
+	*r = results
+	return nil
+}
+
+func legacyTestResult(value interface{}) (bool, error) {
+	switch v := value.(type) {
+	case bool:
+		return v, nil
+	case string:
+		if v == TestPassed {
+			return true, nil
+		}
+		if v == TestFailed || v == "" {
+			return false, nil
+		}
+	}
+	return false, fmt.Errorf("invalid test result %v", value)
+}
+
+// flexInt decodes a number written either as a JSON number or, in version 1
+// records, as a string
+type flexInt int
+
+func (n *flexInt) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		var i int
+		if err := json.Unmarshal(data, &i); err != nil {
+			return err
+		}
+		*n = flexInt(i)
+		return nil
+	}
+	if s == "" {
+		*n = 0
+		return nil
+	}
+	i, err := strconv.Atoi(s)
+	if err != nil {
+		return fmt.Errorf("invalid number %q", s)
+	}
+	*n = flexInt(i)
+	return nil
+}
+
+// UnmarshalJSON reads licenses of every schema version: string points and the
+// test1..test3 fields written before the test registry are converted in place
+func (l *License) UnmarshalJSON(data []byte) error {
+	type license License
+	aux := struct {
+		*license
+		Point flexInt `json:"point"`
+		Test1 string  `json:"test1"`
+		Test2 string  `json:"test2"`
+		Test3 string  `json:"test3"`
+	}{license: (*license)(l)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+
+	l.Point = int(aux.Point)
+	if l.Tests == nil {
+		l.Tests = TestResults{}
+		for i, result := range []string{aux.Test1, aux.Test2, aux.Test3} {
+			if result == "" {
+				continue
+			}
+			passed, err := legacyTestResult(result)
+			if err != nil {
+				return err
+			}
+			l.Tests[legacyTests[i]] = passed
+		}
+	}
+	if l.SchemaVersion == 0 {
+		l.SchemaVersion = legacySchemaVersion
+	}
+	l.DocType = DocTypeLicense
+	return nil
+}
+
+// UnmarshalJSON reads reports of every schema version, accepting the string
+// points deduction of version 1
+func (r *TrafficRuleViolatonReport) UnmarshalJSON(data []byte) error {
+	type report TrafficRuleViolatonReport
+	aux := struct {
+		*report
+		PointsDeduction flexInt `json:"pointsdeduction"`
+	}{report: (*report)(r)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+
+	r.PointsDeduction = int(aux.PointsDeduction)
+	if r.SchemaVersion == 0 {
+		r.SchemaVersion = legacySchemaVersion
+	}
+	r.DocType = DocTypeReport
+	return nil
+}
+
+// UnmarshalJSON gives attempts recorded before schema versions version 1
+func (a *TestAttempt) UnmarshalJSON(data []byte) error {
+	type attempt TestAttempt
+	if err := json.Unmarshal(data, (*attempt)(a)); err != nil {
+		return err
+	}
+	if a.SchemaVersion == 0 {
+		a.SchemaVersion = legacySchemaVersion
+	}
+	a.DocType = DocTypeTestAttempt
+	return nil
+}
+
+// timestamp returns a pointer to a copy of t, for the optional dates of a record
+func timestamp(t time.Time) *time.Time {
+	return &t
+}
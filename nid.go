@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	sc "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+// The nid~key index maps the keyed hash of an NID (hashNID) to the license
+// issued for it, so the NID itself never reaches public state. Ledgers written before
+// schema version 4 key the index by the plain NID, and before that it only
+// held legacyNIDMarker; both are still read until migrateLedger rewrites them.
+
+// legacyNIDMarker : value of nid~key entries written before the index pointed
+// at the license ID. Such entries only say the NID is taken; the license is
+// found by scanning the status indexes.
+var legacyNIDMarker = []byte{0x00}
+
+func nidIndexKey(APIstub shim.ChaincodeStubInterface, nidHash string) (string, error) {
+	return APIstub.CreateCompositeKey("nid~key", []string{"current", nidHash})
+}
+
+// legacyNIDIndexKey : key of an index entry written with the plain NID
+func legacyNIDIndexKey(APIstub shim.ChaincodeStubInterface, nid string) (string, error) {
+	return APIstub.CreateCompositeKey("nid~key", []string{"current", nid})
+}
+
+func putNIDIndex(APIstub shim.ChaincodeStubInterface, nidHash string, id string) error {
+	indexKey, err := nidIndexKey(APIstub, nidHash)
+	if err != nil {
+		return err
+	}
+	return APIstub.PutState(indexKey, []byte(id))
+}
+
+// delNIDIndex removes the NID index entries of a license, leaving alone those
+// that belong to another license. Licenses written before schema version 4
+// hold the plain NID their entry is keyed by.
+func delNIDIndex(APIstub shim.ChaincodeStubInterface, license License) error {
+	indexKeys := []string{}
+	if license.NIDHash != "" {
+		indexKey, err := nidIndexKey(APIstub, license.NIDHash)
+		if err != nil {
+			return err
+		}
+		indexKeys = append(indexKeys, indexKey)
+	}
+	if license.NID != "" && license.PIIHash == "" {
+		indexKey, err := legacyNIDIndexKey(APIstub, license.NID)
+		if err != nil {
+			return err
+		}
+		indexKeys = append(indexKeys, indexKey)
+	}
+
+	for _, indexKey := range indexKeys {
+		owner, err := APIstub.GetState(indexKey)
+		if err != nil {
+			return err
+		}
+		if owner == nil || (string(owner) != license.ID && !bytes.Equal(owner, legacyNIDMarker)) {
+			continue
+		}
+		if err := APIstub.DelState(indexKey); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// licenseIDForNID returns the ID of the license issued for an NID, or "" if
+// there is none. key is the NID key.
+func licenseIDForNID(APIstub shim.ChaincodeStubInterface, key []byte, nid string) (string, error) {
+	indexKey, err := nidIndexKey(APIstub, hashNID(key, nid))
+	if err != nil {
+		return "", err
+	}
+	value, err := APIstub.GetState(indexKey)
+	if err != nil {
+		return "", err
+	}
+	if value == nil {
+		if indexKey, err = legacyNIDIndexKey(APIstub, nid); err != nil {
+			return "", err
+		}
+		if value, err = APIstub.GetState(indexKey); err != nil {
+			return "", err
+		}
+	}
+	if value == nil {
+		return "", nil
+	}
+	if !bytes.Equal(value, legacyNIDMarker) {
+		return string(value), nil
+	}
+
+	// only licenses written before schema version 4 have a marker entry, and
+	// they still hold the NID in public state. Archived licenses have released
+	// their NID.
+	statuses := []string{StatusLearner, StatusWaiting, StatusActive, StatusToStall, StatusStalled, StatusExpired}
+	for _, status := range statuses {
+		licenses, err := licensesInStatus(APIstub, status)
+		if err != nil {
+			return "", err
+		}
+		for _, license := range licenses {
+			if license.NID == nid {
+				return license.ID, nil
+			}
+		}
+	}
+	return "", nil
+}
+
+// setNIDKey stores the NID key the nid~key index is keyed with in
+// PIICollection. It is passed in the transient map (NIDKeyTransientKey) as at
+// least minSecretBytes random bytes in hex, and the Org1 applications that
+// create licenses and correct NIDs keep it to pass it again. Every index entry
+// is keyed with it, so it can only be set once. args: none
+func (s *SmartContract) setNIDKey(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) != 0 {
+		return shim.Error("Incorrect number of arguments. Expecting 0")
+	}
+
+	key, err := transientSecret(APIstub, NIDKeyTransientKey, "NID key")
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	storedKey, err := nidKeyKey(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	storedHash, err := APIstub.GetPrivateDataHash(PIICollection, storedKey)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if storedHash != nil {
+		return shim.Error("The NID key is already set")
+	}
+	if err := APIstub.PutPrivateData(PIICollection, storedKey, key); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// queryLicenseByNID returns the license issued for a national ID. args: none;
+// the transient map carries the NID under PIITransientKey as {"nid":"..."}.
+// The NID key is read from PIICollection on Org1 peers, elsewhere it has to be
+// passed in the transient map (NIDKeyTransientKey).
+func (s *SmartContract) queryLicenseByNID(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) == 1 {
+		return shim.Error("The NID is no longer accepted as an argument, pass it in the transient map under \"" + PIITransientKey + "\"")
+	}
+	if len(args) != 0 {
+		return shim.Error("Incorrect number of arguments. Expecting 0")
+	}
+
+	input, err := transientPII(APIstub, "nid")
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	key, err := readNIDKey(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	id, err := licenseIDForNID(APIstub, key, input.NID)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if id == "" {
+		return shim.Error("No license is issued for this NID")
+	}
+
+	license, err := getLicense(APIstub, id)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if canReadPII(APIstub) {
+		if err := loadPII(APIstub, &license); err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	licenseAsBytes, err := json.Marshal(license)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(licenseAsBytes)
+}
+
+// correctNID replaces the national ID recorded on a license and moves its
+// index entry. args: license ID; the transient map carries the corrected NID
+// and a fresh salt under PIITransientKey as {"nid":"...","salt":"..."}, the
+// NID key under NIDKeyTransientKey and the personal data the license holds
+// under HolderTransientKey.
+func (s *SmartContract) correctNID(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) == 2 {
+		return shim.Error("The NID is no longer accepted as an argument, pass it in the transient map under \"" + PIITransientKey + "\"")
+	}
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	input, err := transientPII(APIstub, "nid", "salt")
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := rejectPIIArgs(args, input); err != nil {
+		return shim.Error(err.Error())
+	}
+	key, err := transientNIDKey(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	license, err := getLicense(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	pii, err := currentPII(APIstub, license)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if pii.NID == input.NID {
+		return shim.Error("License " + license.ID + " already has this NID")
+	}
+
+	owner, err := licenseIDForNID(APIstub, key, input.NID)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if owner != "" {
+		return shim.Error("NID already exists")
+	}
+
+	pii.NID, pii.Salt = input.NID, input.Salt
+	if err := setPII(APIstub, &license, pii, key); err != nil {
+		return shim.Error(err.Error())
+	}
+	emit(APIstub, Event{Type: EventNIDCorrected, LicenseID: license.ID})
+
+	licenseAsBytes, err := putLicense(APIstub, license)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(licenseAsBytes)
+}
@@ -0,0 +1,441 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	sc "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+// PIICollection : private data collection holding the personal data of license
+// holders and the NID key, stored on Org1 peers only (collections_config.json).
+// Who reads it is decided by the ACL (readPIIRight), so police can look up an
+// NID on an Org1 peer. The public license keeps a salted hash of the data so
+// the private copy can be checked, and the keyed hash of the NID the nid~key
+// index is keyed by.
+//
+// The peers of Org2 endorse every invoke without holding the collection, so
+// invokes never read it: what they need of it is passed in the transient map
+// and checked against hashes every peer has.
+//
+// Licenses written before schema version 4 held this data in public state;
+// migrateLedger moves it here, but their earlier versions stay in the history.
+const PIICollection = "collectionLicensePII"
+
+// readPIIRight : ACL entry deciding which callers get personal data merged
+// into the licenses they query. It is not a function.
+const readPIIRight = "readPII"
+
+// PIITransientKey : transient map entry carrying the personal data given to
+// createLearnerLicense and correctNID. Transient data reaches the chaincode
+// without being stored in the transaction, unlike arguments.
+const PIITransientKey = "pii"
+
+// NIDKeyTransientKey : transient map entry carrying the NID key, as a JSON
+// string of hex, to the invokes that key the nid~key index with it
+const NIDKeyTransientKey = "nidKey"
+
+// HolderTransientKey : transient map entry carrying the personal data a license
+// holds, as returned by queryHolderPII, to the invokes that need it
+const HolderTransientKey = "holder"
+
+// minSecretBytes : fewest random bytes accepted for the NID key and for salts
+const minSecretBytes = 32
+
+// maxNameLength : longest holder name accepted
+const maxNameLength = 200
+
+// PIIInput : JSON payload of PIITransientKey, e.g.
+// {"name":"Jane Doe","nid":"1990123456","dob":"1990-04-01","salt":"<hex>"}.
+// Salt is fresh random bytes in hex that the public hash of the data is salted
+// with, at least minSecretBytes of them.
+type PIIInput struct {
+	Name string `json:"name"`
+	NID  string `json:"nid"`
+	DOB  string `json:"dob"`
+	Salt string `json:"salt"`
+}
+
+// HolderPII : personal data of a license holder, stored under the license ID
+type HolderPII struct {
+	LicenseID string `json:"licenseid"`
+	Name      string `json:"name"`
+	NID       string `json:"nid"`
+	DOB       string `json:"dob"`
+	Salt      string `json:"salt"`
+}
+
+// hashNID gives the key of an NID in the nid~key index. The index has to be
+// found from the NID alone, so the hash is not salted but keyed with the NID
+// key, which only PIICollection holds, so NIDs can not be guessed from it.
+func hashNID(key []byte, nid string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("nid:" + nid))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// nidKeyKey : PIICollection key of the NID key
+func nidKeyKey(APIstub shim.ChaincodeStubInterface) (string, error) {
+	return APIstub.CreateCompositeKey("nidkey~key", []string{"current"})
+}
+
+// decodeSecret decodes random bytes given in hex, refusing fewer than minSecretBytes
+func decodeSecret(name string, value string) ([]byte, error) {
+	secret, err := hex.DecodeString(value)
+	if err != nil || len(secret) < minSecretBytes {
+		return nil, fmt.Errorf("Invalid %s, expecting at least %d random bytes in hex", name, minSecretBytes)
+	}
+	return secret, nil
+}
+
+// transientSecret reads a secret passed in the transient map as a JSON string of hex
+func transientSecret(APIstub shim.ChaincodeStubInterface, transientKey string, name string) ([]byte, error) {
+	transient, err := APIstub.GetTransient()
+	if err != nil {
+		return nil, err
+	}
+	payload, ok := transient[transientKey]
+	if !ok {
+		return nil, fmt.Errorf("The %s must be passed in the transient map under %q", name, transientKey)
+	}
+	value := ""
+	if err := json.Unmarshal(payload, &value); err != nil {
+		return nil, fmt.Errorf("Invalid %q transient data: %s", transientKey, err.Error())
+	}
+	return decodeSecret(name, value)
+}
+
+// transientNIDKey reads the NID key from the transient map and checks it
+// against the hash of the copy in PIICollection, which every peer has
+func transientNIDKey(APIstub shim.ChaincodeStubInterface) ([]byte, error) {
+	key, err := transientSecret(APIstub, NIDKeyTransientKey, "NID key")
+	if err != nil {
+		return nil, err
+	}
+	storedKey, err := nidKeyKey(APIstub)
+	if err != nil {
+		return nil, err
+	}
+	storedHash, err := APIstub.GetPrivateDataHash(PIICollection, storedKey)
+	if err != nil {
+		return nil, err
+	}
+	if storedHash == nil {
+		return nil, fmt.Errorf("No NID key is set, it has to be set with setNIDKey first")
+	}
+	sum := sha256.Sum256(key)
+	if !hmac.Equal(sum[:], storedHash) {
+		return nil, fmt.Errorf("The NID key passed in the transient map is not the one set with setNIDKey")
+	}
+	return key, nil
+}
+
+// readNIDKey returns the NID key passed in the transient map, or else reads it
+// from PIICollection, which only works on Org1 peers. It is for queries:
+// invokes take the key from the transient map only.
+func readNIDKey(APIstub shim.ChaincodeStubInterface) ([]byte, error) {
+	transient, err := APIstub.GetTransient()
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := transient[NIDKeyTransientKey]; ok {
+		return transientNIDKey(APIstub)
+	}
+
+	storedKey, err := nidKeyKey(APIstub)
+	if err != nil {
+		return nil, err
+	}
+	key, err := APIstub.GetPrivateData(PIICollection, storedKey)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read the NID key, query an Org1 peer or pass the key in the transient map under %q: %s", NIDKeyTransientKey, err.Error())
+	}
+	if key == nil {
+		return nil, fmt.Errorf("No NID key is set, it has to be set with setNIDKey first")
+	}
+	return key, nil
+}
+
+// piiHash gives the public hash of a holder's personal data
+func piiHash(pii HolderPII) string {
+	sum := sha256.Sum256([]byte(pii.Salt + "\x00" + pii.Name + "\x00" + pii.NID + "\x00" + pii.DOB))
+	return hex.EncodeToString(sum[:])
+}
+
+// hasPII reports whether the license carries personal data in memory, either
+// loaded with loadPII, given at creation or read from a pre-version 4 record
+func hasPII(license License) bool {
+	return license.Name != "" || license.NID != "" || license.DOB != ""
+}
+
+// putPII writes the personal data of the license to PIICollection and sets the
+// hashes the public record keeps of it, PIIHash salted with the given salt and
+// NIDHash keyed with the NID key
+func putPII(APIstub shim.ChaincodeStubInterface, license *License, salt string, key []byte) error {
+	pii := HolderPII{LicenseID: license.ID, Name: license.Name, NID: license.NID, DOB: license.DOB, Salt: salt}
+	piiAsBytes, err := json.Marshal(pii)
+	if err != nil {
+		return err
+	}
+	if err := APIstub.PutPrivateData(PIICollection, license.ID, piiAsBytes); err != nil {
+		return fmt.Errorf("Failed to write personal data of license %s: %s", license.ID, err.Error())
+	}
+
+	license.PIIHash = piiHash(pii)
+	license.NIDHash = ""
+	if license.NID != "" {
+		license.NIDHash = hashNID(key, license.NID)
+	}
+	return nil
+}
+
+// setPII gives a license new personal data with putPII and moves its nid~key
+// entry to the new NID. Archived licenses have released their NID and get no
+// entry. The caller writes the license.
+func setPII(APIstub shim.ChaincodeStubInterface, license *License, pii HolderPII, key []byte) error {
+	previous := *license
+	license.Name, license.NID, license.DOB = pii.Name, pii.NID, pii.DOB
+	if err := putPII(APIstub, license, pii.Salt, key); err != nil {
+		return err
+	}
+
+	if err := delNIDIndex(APIstub, previous); err != nil {
+		return err
+	}
+	if license.NIDHash == "" || license.Status == StatusCancelled {
+		return nil
+	}
+	return putNIDIndex(APIstub, license.NIDHash, license.ID)
+}
+
+// readHolderPII reads the personal data of a license from PIICollection,
+// checking it against the public hash. It only works on Org1 peers.
+func readHolderPII(APIstub shim.ChaincodeStubInterface, license License) (HolderPII, error) {
+	pii := HolderPII{}
+	piiAsBytes, err := APIstub.GetPrivateData(PIICollection, license.ID)
+	if err != nil {
+		return pii, fmt.Errorf("Failed to read personal data of license %s: %s", license.ID, err.Error())
+	}
+	if piiAsBytes == nil {
+		return pii, fmt.Errorf("No personal data is stored for license %s", license.ID)
+	}
+	if err := json.Unmarshal(piiAsBytes, &pii); err != nil {
+		return pii, fmt.Errorf("Failed to decode personal data of license %s: %s", license.ID, err.Error())
+	}
+	if piiHash(pii) != license.PIIHash {
+		return pii, fmt.Errorf("Personal data of license %s does not match its public hash", license.ID)
+	}
+	return pii, nil
+}
+
+// loadPII fills in the personal data of a license from PIICollection. It is
+// for queries, see readHolderPII.
+func loadPII(APIstub shim.ChaincodeStubInterface, license *License) error {
+	if hasPII(*license) || license.PIIHash == "" {
+		return nil
+	}
+
+	pii, err := readHolderPII(APIstub, *license)
+	if err != nil {
+		return err
+	}
+	license.Name, license.NID, license.DOB = pii.Name, pii.NID, pii.DOB
+	return nil
+}
+
+// currentPII returns the personal data a license holds for invokes, which can
+// not read PIICollection: it is passed in the transient map (HolderTransientKey)
+// as queryHolderPII returned it and checked against the public hash. Licenses
+// still holding their data in public state, or holding none, need nothing
+// passed.
+func currentPII(APIstub shim.ChaincodeStubInterface, license License) (HolderPII, error) {
+	if license.PIIHash == "" {
+		return HolderPII{LicenseID: license.ID, Name: license.Name, NID: license.NID, DOB: license.DOB}, nil
+	}
+
+	transient, err := APIstub.GetTransient()
+	if err != nil {
+		return HolderPII{}, err
+	}
+	payload, ok := transient[HolderTransientKey]
+	if !ok {
+		return HolderPII{}, fmt.Errorf("The personal data of license %s must be passed in the transient map under %q, as queryHolderPII returns it", license.ID, HolderTransientKey)
+	}
+	pii := HolderPII{}
+	if err := json.Unmarshal(payload, &pii); err != nil {
+		return pii, fmt.Errorf("Invalid %q transient data: %s", HolderTransientKey, err.Error())
+	}
+	if pii.LicenseID != license.ID || piiHash(pii) != license.PIIHash {
+		return pii, fmt.Errorf("Personal data passed for license %s does not match its public hash", license.ID)
+	}
+	return pii, nil
+}
+
+// queryHolderPII returns the personal data of a license with the salt of its
+// public hash, to be passed back to the invokes that need it under
+// HolderTransientKey. args: license ID
+func (s *SmartContract) queryHolderPII(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	license, err := getLicense(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	pii := HolderPII{LicenseID: license.ID, Name: license.Name, NID: license.NID, DOB: license.DOB}
+	if license.PIIHash != "" {
+		if pii, err = readHolderPII(APIstub, license); err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	piiAsBytes, err := json.Marshal(pii)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(piiAsBytes)
+}
+
+// correctDOB records or corrects the date of birth of a license holder, e.g.
+// for licenses issued before it was recorded, which can not be endorsed
+// without it. args: license ID; the transient map carries the date of birth
+// and a fresh salt under PIITransientKey as {"dob":"YYYY-MM-DD","salt":"..."},
+// the personal data the license holds under HolderTransientKey and, if it
+// holds an NID, the NID key under NIDKeyTransientKey.
+func (s *SmartContract) correctDOB(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	input, err := transientPII(APIstub, "dob", "salt")
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := rejectPIIArgs(args, input); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	license, err := getLicense(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	pii, err := currentPII(APIstub, license)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if pii.DOB == input.DOB {
+		return shim.Error("License " + license.ID + " already has this date of birth")
+	}
+	var key []byte
+	if pii.NID != "" {
+		if key, err = transientNIDKey(APIstub); err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	pii.DOB, pii.Salt = input.DOB, input.Salt
+	if err := setPII(APIstub, &license, pii, key); err != nil {
+		return shim.Error(err.Error())
+	}
+	emit(APIstub, Event{Type: EventDOBCorrected, LicenseID: license.ID})
+
+	licenseAsBytes, err := putLicense(APIstub, license)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(licenseAsBytes)
+}
+
+// canReadPII reports whether the caller may see personal data
+func canReadPII(APIstub shim.ChaincodeStubInterface) bool {
+	return authorize(APIstub, readPIIRight) == nil
+}
+
+// withPII merges personal data into licenses returned to a caller allowed to
+// see it; other callers get the public records unchanged
+func withPII(APIstub shim.ChaincodeStubInterface, licenses []License) ([]License, error) {
+	if !canReadPII(APIstub) {
+		return licenses, nil
+	}
+	for i := range licenses {
+		if err := loadPII(APIstub, &licenses[i]); err != nil {
+			return nil, err
+		}
+	}
+	return licenses, nil
+}
+
+// transientPII reads the personal data of the transient map. Only the fields
+// listed in required may be given and all of them must be.
+func transientPII(APIstub shim.ChaincodeStubInterface, required ...string) (PIIInput, error) {
+	input := PIIInput{}
+	transient, err := APIstub.GetTransient()
+	if err != nil {
+		return input, err
+	}
+	payload, ok := transient[PIITransientKey]
+	if !ok {
+		return input, fmt.Errorf("Personal data (%s) must be passed in the transient map under %q", strings.Join(required, ", "), PIITransientKey)
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&input); err != nil {
+		return input, fmt.Errorf("Invalid %q transient data: %s", PIITransientKey, err.Error())
+	}
+	input.Name, input.NID, input.DOB = strings.TrimSpace(input.Name), strings.TrimSpace(input.NID), strings.TrimSpace(input.DOB)
+
+	fields := map[string]string{"name": input.Name, "nid": input.NID, "dob": input.DOB, "salt": input.Salt}
+	for field, value := range fields {
+		if value != "" && !contains(required, field) {
+			return input, fmt.Errorf("Transient data %q can not set %s here", PIITransientKey, field)
+		}
+	}
+	for _, field := range required {
+		if fields[field] == "" {
+			return input, fmt.Errorf("Transient data %q is missing %s", PIITransientKey, field)
+		}
+	}
+
+	if input.Name != "" && (len(input.Name) > maxNameLength || strings.IndexFunc(input.Name, unicode.IsControl) >= 0) {
+		return input, fmt.Errorf("Invalid name, expecting at most %d printable characters", maxNameLength)
+	}
+	if input.NID != "" && strings.IndexFunc(input.NID, func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) }) >= 0 {
+		return input, fmt.Errorf("Invalid NID, expecting letters and digits only")
+	}
+	if input.DOB != "" {
+		if _, err := time.Parse("2006-01-02", input.DOB); err != nil {
+			return input, fmt.Errorf("Invalid date of birth %q, expecting YYYY-MM-DD", input.DOB)
+		}
+	}
+	if input.Salt != "" {
+		if _, err := decodeSecret("salt", input.Salt); err != nil {
+			return input, err
+		}
+	}
+	return input, nil
+}
+
+// rejectPIIArgs fails if personal data was also passed as an argument, where
+// it would be written to the ledger with the transaction
+func rejectPIIArgs(args []string, input PIIInput) error {
+	for _, arg := range args {
+		for _, value := range []string{input.Name, input.NID, input.DOB, input.Salt} {
+			if value != "" && arg == value {
+				return fmt.Errorf("Personal data must only be passed in the transient map under %q, not as an argument", PIITransientKey)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	sc "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+// reportsForLicense loads every violation report filed against a license through crime~key
+func reportsForLicense(APIstub shim.ChaincodeStubInterface, licenseID string) ([]TrafficRuleViolatonReport, error) {
+	resultsIterator, err := APIstub.GetStateByPartialCompositeKey("crime~key", []string{licenseID})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	var reports []TrafficRuleViolatonReport
+	for resultsIterator.HasNext() {
+		responseRange, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		_, compositeKeyParts, err := APIstub.SplitCompositeKey(responseRange.Key)
+		if err != nil {
+			return nil, err
+		}
+
+		reportAsBytes, err := APIstub.GetState(compositeKeyParts[1])
+		if err != nil {
+			return nil, err
+		}
+		if reportAsBytes == nil {
+			continue
+		}
+		report := TrafficRuleViolatonReport{}
+		if err := json.Unmarshal(reportAsBytes, &report); err != nil {
+			return nil, fmt.Errorf("Failed to decode report %s: %s", compositeKeyParts[1], err.Error())
+		}
+		reports = append(reports, report)
+	}
+
+	return reports, nil
+}
+
+// pointsAnchor is the start of the current violation-free period: the latest of
+// the last report that was not dismissed on appeal, the last restoration and the
+// last reinstatement. Reports filed before report dates were recorded can not be
+// placed in time and are left out; a zero time means there is nothing to count from.
+func pointsAnchor(APIstub shim.ChaincodeStubInterface, license License) (time.Time, error) {
+	var anchor time.Time
+
+	later := func(date *time.Time) {
+		if date != nil && date.After(anchor) {
+			anchor = *date
+		}
+	}
+
+	reports, err := reportsForLicense(APIstub, license.ID)
+	if err != nil {
+		return anchor, err
+	}
+	for _, report := range reports {
+		if report.Status == ReportDismissed {
+			continue
+		}
+		later(report.Date)
+	}
+	later(license.PointsRestoredDate)
+	later(license.ReinstatedDate)
+
+	return anchor, nil
+}
+
+// projectPoints gives back PointRestoreAmount points for every full
+// PointRestorePeriodDays the license went without a violation, up to
+// InitialPoints. Only the license in memory is changed; it reports whether
+// anything was restored.
+func projectPoints(APIstub shim.ChaincodeStubInterface, license *License, now time.Time) (bool, error) {
+	if license.Status != StatusActive && license.Status != StatusToStall {
+		return false, nil
+	}
+
+	points := license.Point
+	if points >= InitialPoints {
+		return false, nil
+	}
+
+	config, err := getConfig(APIstub)
+	if err != nil {
+		return false, err
+	}
+	if config.PointRestoreAmount == 0 {
+		return false, nil
+	}
+
+	anchor, err := pointsAnchor(APIstub, *license)
+	if err != nil {
+		return false, err
+	}
+	if anchor.IsZero() {
+		return false, nil
+	}
+
+	period := days(config.PointRestorePeriodDays)
+	periods := int(now.Sub(anchor) / period)
+	if periods <= 0 {
+		return false, nil
+	}
+
+	points += periods * config.PointRestoreAmount
+	if points > InitialPoints {
+		points = InitialPoints
+	}
+	license.Point = points
+	// the next period starts where the last full one ended, not now, so the
+	// result does not depend on how often points are recalculated
+	license.PointsRestoredDate = timestamp(anchor.Add(time.Duration(periods) * period))
+
+	return true, nil
+}
+
+// restorePoints applies projectPoints and moves a license flagged for stalling
+// back to Active once it has points again. The caller writes the license.
+func restorePoints(APIstub shim.ChaincodeStubInterface, license *License, now time.Time) (bool, error) {
+	restored, err := projectPoints(APIstub, license, now)
+	if err != nil || !restored {
+		return restored, err
+	}
+	points := license.Point
+	emit(APIstub, Event{Type: EventPointsChanged, LicenseID: license.ID, Points: &points, Reason: PointsRestored})
+
+	if license.Status == StatusToStall && license.Point > 0 {
+		if err := setLicenseStatus(APIstub, license, StatusActive); err != nil {
+			return false, err
+		}
+	}
+	if err := applyClassThresholds(APIstub, license); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// recalculatePoints writes the points restored to a license since its last violation
+func (s *SmartContract) recalculatePoints(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	license, err := getLicense(APIstub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	now, err := txTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	restored, err := restorePoints(APIstub, &license, now)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if !restored {
+		licenseAsBytes, err := json.Marshal(license)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		return shim.Success(licenseAsBytes)
+	}
+
+	licenseAsBytes, err := putLicense(APIstub, license)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(licenseAsBytes)
+}
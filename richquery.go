@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	sc "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+// Rich queries run against the CouchDB state database and are backed by the
+// indexes under META-INF/statedb/couchdb/indexes. They only see records that
+// carry a docType, so ledgers written before schema version 3 have to go
+// through migrateLedger first.
+
+// ReportPage : one page of a paginated report list. Bookmark is passed to the
+// next call until it comes back empty.
+type ReportPage struct {
+	Records             []TrafficRuleViolatonReport `json:"records"`
+	FetchedRecordsCount int32                       `json:"fetchedRecordsCount"`
+	Bookmark            string                      `json:"bookmark"`
+}
+
+// richQueryArgs reads the selector, page size and optional bookmark of a rich
+// query and builds the CouchDB query restricted to one document type
+func richQueryArgs(docType string, args []string) (string, int32, string, error) {
+	if len(args) != 2 && len(args) != 3 {
+		return "", 0, "", fmt.Errorf("Incorrect number of arguments. Expecting 2 or 3")
+	}
+
+	selector := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(args[0]), &selector); err != nil {
+		return "", 0, "", fmt.Errorf("Invalid selector: %s", err.Error())
+	}
+	bookmark := ""
+	if len(args) == 3 {
+		bookmark = args[2]
+	}
+	pageSize, bookmark, err := parsePageArgs(args[1], bookmark)
+	if err != nil {
+		return "", 0, "", err
+	}
+
+	query, err := json.Marshal(map[string]interface{}{
+		"selector": map[string]interface{}{
+			"$and": []interface{}{map[string]interface{}{"docType": docType}, selector},
+		},
+	})
+	if err != nil {
+		return "", 0, "", err
+	}
+
+	return string(query), pageSize, bookmark, nil
+}
+
+// licenseQueryPage runs a CouchDB query for license documents
+func licenseQueryPage(APIstub shim.ChaincodeStubInterface, query string, pageSize int32, bookmark string) sc.Response {
+	resultsIterator, metadata, err := APIstub.GetQueryResultWithPagination(query, pageSize, bookmark)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	page := LicensePage{Records: []License{}}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		license := License{}
+		if err := json.Unmarshal(queryResponse.Value, &license); err != nil {
+			return shim.Error("Failed to decode license " + queryResponse.Key + ": " + err.Error())
+		}
+		page.Records = append(page.Records, license)
+	}
+	page.FetchedRecordsCount = metadata.FetchedRecordsCount
+	page.Bookmark = metadata.Bookmark
+	if page.Records, err = withPII(APIstub, page.Records); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	pageAsBytes, err := json.Marshal(page)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(pageAsBytes)
+}
+
+// reportQueryPage runs a CouchDB query for report documents
+func reportQueryPage(APIstub shim.ChaincodeStubInterface, query string, pageSize int32, bookmark string) sc.Response {
+	resultsIterator, metadata, err := APIstub.GetQueryResultWithPagination(query, pageSize, bookmark)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	page := ReportPage{Records: []TrafficRuleViolatonReport{}}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		report := TrafficRuleViolatonReport{}
+		if err := json.Unmarshal(queryResponse.Value, &report); err != nil {
+			return shim.Error("Failed to decode report " + queryResponse.Key + ": " + err.Error())
+		}
+		if report.Status == "" {
+			report.Status = ReportFiled
+		}
+		page.Records = append(page.Records, report)
+	}
+	page.FetchedRecordsCount = metadata.FetchedRecordsCount
+	page.Bookmark = metadata.Bookmark
+
+	pageAsBytes, err := json.Marshal(page)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(pageAsBytes)
+}
+
+// queryLicenseDocuments runs a CouchDB selector over license documents.
+// args: selector JSON, page size, optionally a bookmark, e.g.
+// {"status":"Active","point":{"$lt":5}}
+func (s *SmartContract) queryLicenseDocuments(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+	query, pageSize, bookmark, err := richQueryArgs(DocTypeLicense, args)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return licenseQueryPage(APIstub, query, pageSize, bookmark)
+}
+
+// queryReportDocuments runs a CouchDB selector over report documents.
+// args: selector JSON, page size, optionally a bookmark, e.g.
+// {"level":"High","date":{"$gte":"2024-01-01T00:00:00Z"}}
+func (s *SmartContract) queryReportDocuments(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+	query, pageSize, bookmark, err := richQueryArgs(DocTypeReport, args)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return reportQueryPage(APIstub, query, pageSize, bookmark)
+}
+
+// queryReportsByHolder returns the reports filed against a license, newest
+// first. args: license ID, page size, optionally a bookmark.
+func (s *SmartContract) queryReportsByHolder(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) != 2 && len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 2 or 3")
+	}
+	bookmark := ""
+	if len(args) == 3 {
+		bookmark = args[2]
+	}
+	pageSize, bookmark, err := parsePageArgs(args[1], bookmark)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	query, err := json.Marshal(map[string]interface{}{
+		"selector":  map[string]interface{}{"docType": DocTypeReport, "holder": args[0]},
+		"sort":      []interface{}{map[string]string{"docType": "desc"}, map[string]string{"holder": "desc"}, map[string]string{"date": "desc"}},
+		"use_index": []string{"_design/indexHolderDoc", "indexHolder"},
+	})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return reportQueryPage(APIstub, string(query), pageSize, bookmark)
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	sc "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+// defaultSearchPageSize : page size of searches that do not set one
+const defaultSearchPageSize = 50
+
+// DateRange : inclusive date bounds, either of which may be left out
+type DateRange struct {
+	From *time.Time `json:"from,omitempty"`
+	To   *time.Time `json:"to,omitempty"`
+}
+
+func (r *DateRange) matches(date *time.Time) bool {
+	if r == nil {
+		return true
+	}
+	if date == nil {
+		return false
+	}
+	return (r.From == nil || !date.Before(*r.From)) && (r.To == nil || !date.After(*r.To))
+}
+
+// LicenseFilter : criteria of searchLicenses. Every criterion that is set must
+// match; Tests maps test type IDs to whether the test must be passed or not.
+type LicenseFilter struct {
+	Status            []string        `json:"status,omitempty"`
+	MinPoints         *int            `json:"minPoints,omitempty"`
+	MaxPoints         *int            `json:"maxPoints,omitempty"`
+	Tests             map[string]bool `json:"tests,omitempty"`
+	NamePrefix        string          `json:"namePrefix,omitempty"`
+	IssueDate         *DateRange      `json:"issueDate,omitempty"`
+	ExpiryDate        *DateRange      `json:"expiryDate,omitempty"`
+	LearnerExpiryDate *DateRange      `json:"learnerExpiryDate,omitempty"`
+
+	PageSize int    `json:"pageSize,omitempty"`
+	Bookmark string `json:"bookmark,omitempty"`
+}
+
+func (f LicenseFilter) matches(license License) bool {
+	if f.MinPoints != nil && license.Point < *f.MinPoints {
+		return false
+	}
+	if f.MaxPoints != nil && license.Point > *f.MaxPoints {
+		return false
+	}
+	for id, passed := range f.Tests {
+		if license.Tests[id] != passed {
+			return false
+		}
+	}
+	if f.NamePrefix != "" && !strings.HasPrefix(strings.ToLower(license.Name), strings.ToLower(f.NamePrefix)) {
+		return false
+	}
+	return f.IssueDate.matches(license.IssueDate) &&
+		f.ExpiryDate.matches(license.ExpiryDate) &&
+		f.LearnerExpiryDate.matches(license.LearnerExpiryDate)
+}
+
+// searchPage reads one page of the index of a status for searchLicenses. It
+// returns the licenses listed in the page and the bookmark of the next one, or
+// "" once the index is exhausted.
+func searchPage(APIstub shim.ChaincodeStubInterface, status string, pageSize int32, bookmark string) ([]License, string, error) {
+	resultsIterator, metadata, err := APIstub.GetStateByPartialCompositeKeyWithPagination(statusIndex[status], []string{"current"}, pageSize, bookmark)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resultsIterator.Close()
+
+	licenses := []License{}
+	for resultsIterator.HasNext() {
+		responseRange, err := resultsIterator.Next()
+		if err != nil {
+			return nil, "", err
+		}
+		_, compositeKeyParts, err := APIstub.SplitCompositeKey(responseRange.Key)
+		if err != nil {
+			return nil, "", err
+		}
+		license, err := getLicense(APIstub, compositeKeyParts[1])
+		if err != nil {
+			return nil, "", err
+		}
+		licenses = append(licenses, license)
+	}
+
+	if metadata.FetchedRecordsCount < pageSize {
+		return licenses, "", nil
+	}
+	return licenses, metadata.Bookmark, nil
+}
+
+// searchLicenses returns one page of the licenses matching a filter. The
+// indexes of the requested statuses, or all of them, are paged through in turn,
+// so licenses come ordered by status, then by ID. The returned bookmark is the
+// status the next page starts in and the bookmark of its index, joined by ":".
+// args: filter JSON, e.g. {"status":["Active"],"maxPoints":4,"pageSize":20}
+func (s *SmartContract) searchLicenses(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	filter := LicenseFilter{}
+	decoder := json.NewDecoder(strings.NewReader(args[0]))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&filter); err != nil {
+		return shim.Error("Invalid filter: " + err.Error())
+	}
+
+	if len(filter.Status) == 0 {
+		for status := range statusIndex {
+			filter.Status = append(filter.Status, status)
+		}
+		sort.Strings(filter.Status)
+	}
+	for _, status := range filter.Status {
+		if _, ok := statusIndex[status]; !ok {
+			return shim.Error("Unknown license status " + status)
+		}
+	}
+
+	if filter.PageSize == 0 {
+		filter.PageSize = defaultSearchPageSize
+	}
+	if filter.PageSize < 0 {
+		return shim.Error("Page size must be a positive number")
+	}
+	statuses, bookmark := filter.Status, ""
+	if filter.Bookmark != "" {
+		parts := strings.SplitN(filter.Bookmark, ":", 2)
+		for len(statuses) > 0 && statuses[0] != parts[0] {
+			statuses = statuses[1:]
+		}
+		if len(parts) != 2 || len(statuses) == 0 {
+			return shim.Error("Invalid bookmark " + filter.Bookmark)
+		}
+		bookmark = parts[1]
+	}
+
+	// names are personal data, only callers who may see them can search by them
+	byName := filter.NamePrefix != ""
+	if byName && !canReadPII(APIstub) {
+		return shim.Error("Access denied: searching by name needs access to personal data")
+	}
+
+	// every license read may match, so each index page is cut to what is left
+	// of the search page
+	page := LicensePage{Records: []License{}}
+	for len(statuses) > 0 && len(page.Records) < filter.PageSize {
+		licenses, next, err := searchPage(APIstub, statuses[0], int32(filter.PageSize-len(page.Records)), bookmark)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if byName {
+			if licenses, err = withPII(APIstub, licenses); err != nil {
+				return shim.Error(err.Error())
+			}
+		}
+		for _, license := range licenses {
+			if filter.matches(license) {
+				page.Records = append(page.Records, license)
+			}
+		}
+
+		bookmark = next
+		if bookmark == "" {
+			statuses = statuses[1:]
+		}
+	}
+	if len(statuses) > 0 {
+		page.Bookmark = statuses[0] + ":" + bookmark
+	}
+	page.FetchedRecordsCount = int32(len(page.Records))
+	records, err := withPII(APIstub, page.Records)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	page.Records = records
+
+	pageAsBytes, err := json.Marshal(page)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(pageAsBytes)
+}
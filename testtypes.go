@@ -0,0 +1,452 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/pkg/cid"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	sc "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+// TestType : a test of the license battery, such as the written or road test.
+// Role is the exam center role allowed to record its results, and Required
+// tests are asked of every new learner. A learner gets MaxAttempts tries at a
+// test (0 for no limit) and has to wait CooldownDays after a failed one.
+type TestType struct {
+	ID           string `json:"id"`
+	Name         string `json:"name"`
+	Role         string `json:"role"`
+	Required     bool   `json:"required"`
+	PassMark     int    `json:"passmark"`
+	MaxAttempts  int    `json:"maxattempts"`
+	CooldownDays int    `json:"cooldowndays"`
+}
+
+// TestAttempt : one sitting of a test, stored under attempt~key so the history
+// of a license is never overwritten
+type TestAttempt struct {
+	DocType       string `json:"docType"`
+	SchemaVersion int    `json:"schemaVersion"`
+
+	LicenseID string    `json:"licenseid"`
+	Class     string    `json:"class,omitempty"`
+	TestType  string    `json:"testtype"`
+	Attempt   int       `json:"attempt"`
+	Score     int       `json:"score"`
+	PassMark  int       `json:"passmark"`
+	Passed    bool      `json:"passed"`
+	Examiner  string    `json:"examiner"`
+	Center    string    `json:"center"`
+	Date      time.Time `json:"date"`
+	// Legacy attempts were recorded as Yes/No by the inputTestNResult
+	// functions, which are not held to retake limits or cooldowns
+	Legacy bool `json:"legacy,omitempty"`
+}
+
+// Test results as sent to the inputTestNResult functions and stored by
+// schema version 1
+const (
+	TestPassed = "Yes"
+	TestFailed = "No"
+)
+
+// legacyTests : the tests every license took before the test registry, stored
+// in the old test1/test2/test3 fields
+var legacyTests = []string{"test1", "test2", "test3"}
+
+// defaultTestTypes : test types known before any is registered on the ledger.
+// Types registered on the ledger override these by ID.
+var defaultTestTypes = map[string]TestType{
+	"test1": {ID: "test1", Name: "Written", Role: "org1-examcenter1", Required: true, PassMark: 60, MaxAttempts: 3, CooldownDays: 7},
+	"test2": {ID: "test2", Name: "Simulator", Role: "org1-examcenter2", Required: true, PassMark: 60, MaxAttempts: 3, CooldownDays: 7},
+	"test3": {ID: "test3", Name: "Road test", Role: "org1-examcenter3", Required: true, PassMark: 60, MaxAttempts: 3, CooldownDays: 14},
+}
+
+// getTestTypes returns the test registry: defaultTestTypes overlaid with the
+// types stored under testtype~key
+func getTestTypes(APIstub shim.ChaincodeStubInterface) (map[string]TestType, error) {
+	testTypes := map[string]TestType{}
+	for id, testType := range defaultTestTypes {
+		testTypes[id] = testType
+	}
+
+	resultsIterator, err := APIstub.GetStateByPartialCompositeKey("testtype~key", []string{})
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	for resultsIterator.HasNext() {
+		responseRange, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		testType := TestType{}
+		if err := json.Unmarshal(responseRange.Value, &testType); err != nil {
+			return nil, fmt.Errorf("Failed to decode test type %s: %s", responseRange.Key, err.Error())
+		}
+		testTypes[testType.ID] = testType
+	}
+
+	return testTypes, nil
+}
+
+// requiredTests lists, in a stable order, the tests a new learner has to pass
+func requiredTests(testTypes map[string]TestType) []string {
+	var required []string
+	for id, testType := range testTypes {
+		if testType.Required {
+			required = append(required, id)
+		}
+	}
+	sort.Strings(required)
+	return required
+}
+
+func allTestsPassed(required []string, tests TestResults) bool {
+	for _, id := range required {
+		if !tests[id] {
+			return false
+		}
+	}
+	return true
+}
+
+// testAttempts returns the attempts recorded for a license, oldest first, optionally
+// only those of one test type
+func testAttempts(APIstub shim.ChaincodeStubInterface, licenseID string, testTypeID string) ([]TestAttempt, error) {
+	keys := []string{licenseID}
+	if testTypeID != "" {
+		keys = append(keys, testTypeID)
+	}
+
+	resultsIterator, err := APIstub.GetStateByPartialCompositeKey("attempt~key", keys)
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	attempts := []TestAttempt{}
+	for resultsIterator.HasNext() {
+		responseRange, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		attempt := TestAttempt{}
+		if err := json.Unmarshal(responseRange.Value, &attempt); err != nil {
+			return nil, fmt.Errorf("Failed to decode test attempt %s: %s", responseRange.Key, err.Error())
+		}
+		attempts = append(attempts, attempt)
+	}
+
+	return attempts, nil
+}
+
+// attemptsFor keeps the attempts counted against the learner permit of a
+// class: those taken for the class, since the endorsement was requested for
+// an endorsement. Attempts recorded without a class count for the class the
+// license was issued for; legacy attempts are not counted.
+func attemptsFor(history []TestAttempt, license License, classID string, since *time.Time) []TestAttempt {
+	var attempts []TestAttempt
+	for _, attempt := range history {
+		if attempt.Legacy {
+			continue
+		}
+		if attempt.Class != classID && (attempt.Class != "" || classID != license.Class) {
+			continue
+		}
+		if since != nil && attempt.Date.Before(*since) {
+			continue
+		}
+		attempts = append(attempts, attempt)
+	}
+	return attempts
+}
+
+// recordTest stores an attempt at one of the tests a learner is required to take,
+// enforcing the retake limit and cooldown of the test type, and moves the learner
+// to Waiting once every required test is passed. With a class the attempt counts
+// towards the endorsement in progress for that class instead of the learner permit.
+// A legacy attempt skips the retake limit and cooldown and does not count towards them.
+func recordTest(APIstub shim.ChaincodeStubInterface, licenseID string, classID string, testTypeID string, score int, legacy bool) sc.Response {
+	if score < 0 {
+		return shim.Error("Test score can not be negative")
+	}
+
+	testTypes, err := getTestTypes(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	testType, ok := testTypes[testTypeID]
+	if !ok {
+		return shim.Error("Unknown test type " + testTypeID)
+	}
+
+	// the ACL admits every exam center, each one may only record its own tests
+	val, _, err := cid.GetAttributeValue(APIstub, "role")
+	if err != nil {
+		return shim.Error("Error while retriving attributes")
+	}
+	if val != testType.Role {
+		return shim.Error("Only user with role as " + testType.Role + " can record " + testType.Name + " results")
+	}
+	examiner, err := cid.GetID(APIstub)
+	if err != nil {
+		return shim.Error("Error while retriving client identity")
+	}
+
+	license, err := getLicense(APIstub, licenseID)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// the learner permit and each endorsement keep their own results
+	tests, required, expiryDate := license.Tests, license.RequiredTests, license.LearnerExpiryDate
+	var since *time.Time
+	endorsement, endorsing := license.Endorsements[classID]
+	if classID == "" || classID == license.Class && !endorsing {
+		classID = license.Class
+		if license.Status != StatusLearner {
+			return shim.Error("License " + license.ID + " is " + license.Status + ", test results can only be recorded for learners")
+		}
+	} else {
+		if !endorsing {
+			return shim.Error("License " + license.ID + " has no endorsement for " + classID + " in progress")
+		}
+		if license.Status != StatusActive || endorsement.Status != StatusLearner {
+			return shim.Error("Endorsement " + classID + " of license " + license.ID + " is not taking tests")
+		}
+		tests, required, expiryDate = endorsement.Tests, endorsement.RequiredTests, endorsement.LearnerExpiryDate
+		since = endorsement.RequestedDate
+	}
+
+	now, err := txTime(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if permitLapsed(expiryDate, now) {
+		return shim.Error("Learner permit " + license.ID + " has lapsed for " + classID)
+	}
+	if !contains(required, testTypeID) {
+		return shim.Error("License " + license.ID + " does not require the " + testType.Name + " test for " + classID)
+	}
+	if tests[testTypeID] {
+		return shim.Error("License " + license.ID + " already passed the " + testType.Name + " test for " + classID)
+	}
+
+	history, err := testAttempts(APIstub, license.ID, testTypeID)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if !legacy {
+		attempts := attemptsFor(history, license, classID, since)
+		if testType.MaxAttempts > 0 && len(attempts) >= testType.MaxAttempts {
+			return shim.Error("License " + license.ID + " has used all " + strconv.Itoa(testType.MaxAttempts) + " attempts at the " + testType.Name + " test")
+		}
+		if len(attempts) > 0 {
+			last := attempts[len(attempts)-1]
+			retakeAt := last.Date.Add(days(testType.CooldownDays))
+			if now.Before(retakeAt) {
+				return shim.Error("License " + license.ID + " can not retake the " + testType.Name + " test before " + retakeAt.Format(time.RFC3339))
+			}
+		}
+	}
+
+	attempt := TestAttempt{
+		DocType:       DocTypeTestAttempt,
+		SchemaVersion: SchemaVersion,
+		LicenseID:     license.ID,
+		Class:         classID,
+		TestType:      testTypeID,
+		Attempt:       len(history) + 1,
+		Score:         score,
+		PassMark:      testType.PassMark,
+		Passed:        score >= testType.PassMark,
+		Examiner:      examiner,
+		Center:        val,
+		Date:          now,
+		Legacy:        legacy,
+	}
+	// zero padded so attempts sort in the order they were taken
+	attemptKey, err := APIstub.CreateCompositeKey("attempt~key", []string{license.ID, testTypeID, fmt.Sprintf("%04d", attempt.Attempt)})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	attemptAsBytes, err := json.Marshal(attempt)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := APIstub.PutState(attemptKey, attemptAsBytes); err != nil {
+		return shim.Error(err.Error())
+	}
+
+	tests[testTypeID] = attempt.Passed
+	emit(APIstub, Event{Type: EventTestRecorded, LicenseID: license.ID, Class: classID, TestType: testTypeID, Score: &attempt.Score, Passed: &attempt.Passed})
+
+	if allTestsPassed(required, tests) {
+		if endorsing && classID == endorsement.Class {
+			emit(APIstub, Event{Type: EventMovedToWaiting, LicenseID: license.ID, Class: classID, FromStatus: endorsement.Status, ToStatus: StatusWaiting})
+			endorsement.Status = StatusWaiting
+			license.Endorsements[classID] = endorsement
+		} else if err := setLicenseStatus(APIstub, &license, StatusWaiting); err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	licenseAsBytes, err := putLicense(APIstub, license)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(licenseAsBytes)
+}
+
+// recordTestResult records a learner's score for a registered test type.
+// args: license ID, test type ID, score and, for an endorsement, its class ID
+func (s *SmartContract) recordTestResult(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 3 && len(args) != 4 {
+		return shim.Error("Incorrect number of arguments. Expecting 3 or 4")
+	}
+	score, err := strconv.Atoi(args[2])
+	if err != nil {
+		return shim.Error("Test score must be a number")
+	}
+	classID := ""
+	if len(args) == 4 {
+		classID = args[3]
+	}
+
+	return recordTest(APIstub, args[0], classID, args[1], score, false)
+}
+
+// recordLegacyTest records a Yes/No result from the inputTestNResult functions,
+// which predate scores: a pass is recorded at the pass mark and a fail as 0.
+// As before attempts were recorded, a fail can be retaken at once and as often
+// as needed.
+func recordLegacyTest(APIstub shim.ChaincodeStubInterface, licenseID string, testTypeID string, result string) sc.Response {
+	if result != TestPassed && result != TestFailed {
+		return shim.Error("Test result must be " + TestPassed + " or " + TestFailed)
+	}
+
+	testTypes, err := getTestTypes(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	score := 0
+	if result == TestPassed {
+		score = testTypes[testTypeID].PassMark
+	}
+
+	return recordTest(APIstub, licenseID, "", testTypeID, score, true)
+}
+
+// queryTestAttempts returns the attempt history of a license.
+// args: license ID, optionally a test type ID
+func (s *SmartContract) queryTestAttempts(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 1 && len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 1 or 2")
+	}
+	testTypeID := ""
+	if len(args) == 2 {
+		testTypeID = args[1]
+	}
+
+	attempts, err := testAttempts(APIstub, args[0], testTypeID)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	attemptsAsBytes, err := json.Marshal(attempts)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(attemptsAsBytes)
+}
+
+func (s *SmartContract) queryTestTypes(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 0 {
+		return shim.Error("Incorrect number of arguments. Expecting 0")
+	}
+
+	testTypes, err := getTestTypes(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	ids := make([]string, 0, len(testTypes))
+	for id := range testTypes {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	list := make([]TestType, 0, len(ids))
+	for _, id := range ids {
+		list = append(list, testTypes[id])
+	}
+
+	testTypesAsBytes, err := json.Marshal(list)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(testTypesAsBytes)
+}
+
+// registerTestType adds a test type to the registry or replaces one. Learners
+// keep the tests that were required when their permit was created. The role
+// recording the test must already be allowed to call recordTestResult and be
+// bound to an MSP: for a new exam center call updateACL and updateRoleBindings
+// first. args: test type JSON, e.g.
+// {"id":"eyesight","name":"Eyesight","role":"org1-examcenter4","required":true,"passmark":70,"maxattempts":2,"cooldowndays":30}
+func (s *SmartContract) registerTestType(APIstub shim.ChaincodeStubInterface, args []string) sc.Response {
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	testType := TestType{}
+	if err := json.Unmarshal([]byte(args[0]), &testType); err != nil {
+		return shim.Error("Invalid test type: " + err.Error())
+	}
+	if testType.ID == "" || testType.Name == "" || testType.Role == "" {
+		return shim.Error("Test type needs an id, name and role")
+	}
+	if testType.PassMark < 0 || testType.MaxAttempts < 0 || testType.CooldownDays < 0 {
+		return shim.Error("Test type passmark, maxattempts and cooldowndays can not be negative")
+	}
+
+	acl, err := getACL(APIstub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if !contains(acl.Rules["recordTestResult"].Roles, testType.Role) {
+		return shim.Error("Role " + testType.Role + " can not call recordTestResult, add it with updateACL first")
+	}
+	if len(acl.RoleMSPs[testType.Role]) == 0 {
+		return shim.Error("Role " + testType.Role + " is not bound to any MSP, bind it with updateRoleBindings first")
+	}
+
+	key, err := APIstub.CreateCompositeKey("testtype~key", []string{testType.ID})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	testTypeAsBytes, err := json.Marshal(testType)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if err := APIstub.PutState(key, testTypeAsBytes); err != nil {
+		return shim.Error(err.Error())
+	}
+	emit(APIstub, Event{Type: EventTestTypeRegistered, TestType: testType.ID})
+
+	return shim.Success(testTypeAsBytes)
+}